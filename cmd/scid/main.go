@@ -1,19 +1,121 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"log"
 	"log/slog"
+	"maps"
+	"net/http"
 	"os"
+	"slices"
 	"sync"
 	"time"
 
+	"github.com/go-git/go-git/v6/plumbing"
 	"github.com/lmittmann/tint"
 	"sinanmohd.com/scid/internal/config"
 	"sinanmohd.com/scid/internal/driver"
+	"sinanmohd.com/scid/internal/freeze"
 	"sinanmohd.com/scid/internal/git"
 	"sinanmohd.com/scid/internal/health"
 )
 
+// alert only once for every refused commit
+var refusedAlerted plumbing.Hash
+
+var (
+	debounce, minCommitAge time.Duration
+
+	// when HEAD was first seen at headSeenHash, for debounce
+	headSeenHash plumbing.Hash
+	headSeenAt   time.Time
+)
+
+// true if the deploy of NewHash is held back by debounce or min_commit_age,
+// it stays pending since OldHash only moves with MarkDeployed
+func deployHeld(g *git.Git) (bool, error) {
+	if *g.NewHash != headSeenHash {
+		headSeenHash = *g.NewHash
+		headSeenAt = time.Now()
+	}
+	quiet := time.Since(headSeenAt)
+	if quiet < debounce {
+		slog.Info("debouncing, branch moved recently", "newHash", g.NewHash, "wait", debounce-quiet)
+		return true, nil
+	}
+
+	commitTime, err := g.CommitTime()
+	if err != nil {
+		return false, err
+	}
+	age := time.Since(commitTime)
+	if age < minCommitAge {
+		slog.Info("soaking, commit too young", "newHash", g.NewHash, "wait", minCommitAge-age)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// true if a target held back by a freeze can be deployed now
+func queuedDue(g *git.Git) (bool, error) {
+	queued, err := g.Queued()
+	if err != nil || len(queued) == 0 {
+		return false, err
+	}
+
+	var declared map[string][]string
+	err = runIn(g.LocalPath, func() (err error) {
+		declared, err = driver.Targets()
+		return err
+	})
+	if err != nil {
+		slog.Warn("listing declared targets, not pruning the freeze queue", "err", err)
+		declared = make(map[string][]string)
+		for _, target := range queued {
+			declared[target] = nil
+		}
+	} else if !config.Config.DryRun {
+		err = g.QueuePrune(slices.Collect(maps.Keys(declared)))
+		if err != nil {
+			return false, err
+		}
+	}
+
+	now := time.Now()
+	return slices.ContainsFunc(queued, func(target string) bool {
+		aliases, ok := declared[target]
+		return ok && freeze.Active(append([]string{target}, aliases...), now) == nil
+	}), nil
+}
+
+// helm reads values and dependencies outside the charts paths
+func helmSparsePaths(dir string) (paths []string, err error) {
+	err = runIn(dir, func() (err error) {
+		paths, err = driver.HelmSparsePaths(config.Config.Helm)
+		return err
+	})
+	return paths, err
+}
+
+// charts paths are relative to the repo, validate the clone scid deploys from
+func validate(chartName string) error {
+	localPath, err := git.ClonePath(config.Config.RepoUrl, config.Config.Branch)
+	if err != nil {
+		return err
+	}
+	unlock, err := git.Lock(config.Config.RepoUrl, config.Config.Branch)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return runIn(localPath, func() error {
+		return driver.HelmChartsValidate(config.Config.Helm, chartName, os.Stdout)
+	})
+}
+
 func driverRun(g *git.Git) {
 	var wg sync.WaitGroup
 
@@ -35,36 +137,117 @@ func driverRun(g *git.Git) {
 	}()
 
 	wg.Wait()
-}
 
-func scid(config config.SCIDonfig) (err error) {
-	slog.Debug("pulling new changes :)")
-	g, err := git.New(config.RepoUrl, config.Branch, &config.Tag, config.SSH)
+	err := driver.NotifySkipped(g)
 	if err != nil {
-		return err
-	}
-	if !g.HeadMoved() {
-		slog.Debug("no new commits ;(")
-		return
+		slog.Error("notifying skipped targets", "err", err)
 	}
+}
 
+// jobs and helm run relative to the working directory
+func runIn(path string, run func() error) (err error) {
 	originalDir, err := os.Getwd()
 	if err != nil {
 		return err
 	}
-	err = os.Chdir(g.LocalPath)
+	err = os.Chdir(path)
 	if err != nil {
 		return err
 	}
 	defer func() {
-		err = os.Chdir(originalDir)
+		err := os.Chdir(originalDir)
 		if err != nil {
 			log.Fatal(err)
 		}
 	}()
 
+	return run()
+}
+
+func driverRunIn(g *git.Git) error {
+	return runIn(g.LocalPath, func() error {
+		driverRun(g)
+		return nil
+	})
+}
+
+func scid(config config.SCIDonfig) (err error) {
+	unlock, err := git.Lock(config.RepoUrl, config.Branch)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	slog.Debug("pulling new changes :)")
+	g, err := git.New(config.RepoUrl, config.Branch, &config.Tag, config.SSH, config.HTTPS)
+	var unverified *git.UnverifiedError
+	if errors.As(err, &unverified) && unverified.Hash != refusedAlerted {
+		refusedAlerted = unverified.Hash
+		notifyErr := driver.NotifyUnverified(unverified)
+		if notifyErr != nil {
+			slog.Error("notifying unverified commit", "err", notifyErr)
+		}
+	}
+	var tagMoved *git.TagMovedError
+	if errors.As(err, &tagMoved) && tagMoved.NewHash != refusedAlerted {
+		refusedAlerted = tagMoved.NewHash
+		notifyErr := driver.NotifyTagMoved(tagMoved)
+		if notifyErr != nil {
+			slog.Error("notifying moved tag", "err", notifyErr)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	started, ended := freeze.Transitions(time.Now())
+	for _, window := range slices.Concat(started, ended) {
+		err = driver.NotifyFreeze(g, window, slices.Contains(started, window))
+		if err != nil {
+			slog.Error("notifying freeze", "freeze", window.Name, "err", err)
+		}
+	}
+
+	pinned, err := g.Pinned()
+	if err != nil {
+		return err
+	}
+	if pinned {
+		slog.Debug("rolled back from this commit, waiting for the branch to move on", "newHash", g.NewHash)
+		return
+	}
+
+	queuedDue, err := queuedDue(g)
+	if err != nil {
+		return err
+	}
+	if !g.HeadMoved() && !queuedDue {
+		slog.Debug("no new commits ;(")
+		return
+	}
+	held, err := deployHeld(g)
+	if err != nil || held {
+		return err
+	}
+
 	slog.Info("branch HEAD moved", "oldHash", g.OldHash, "newHash", g.NewHash)
-	driverRun(g)
+	if g.HistoryRewritten {
+		err = driver.NotifyHistoryRewritten(g)
+		if err != nil {
+			slog.Error("notifying history rewrite", "err", err)
+		}
+	}
+	err = driverRunIn(g)
+	if err != nil {
+		return err
+	}
+	if !config.DryRun {
+		runID, err := g.MarkDeployed()
+		if err != nil {
+			slog.Error("recording deployed commit", "err", err)
+		} else {
+			slog.Info("run recorded", "runID", runID, "hash", g.NewHash)
+		}
+	}
 	return nil
 }
 
@@ -76,12 +259,63 @@ func main() {
 	if err != nil {
 		log.Fatal("creating config: ", err)
 	}
+	if config.Config.Helm != nil {
+		git.SparseDiscover = helmSparsePaths
+	}
+
+	switch flag.Arg(0) {
+	case "":
+	case "rollback":
+		if flag.NArg() != 2 {
+			log.Fatal("usage: scid rollback <commit|run:id>")
+		}
+		err = rollback(flag.Arg(1))
+		if err != nil {
+			log.Fatal("rolling back: ", err)
+		}
+		return
+	case "validate":
+		if config.Config.Helm == nil {
+			log.Fatal("validating: no helm charts configured")
+		}
+		err = validate(flag.Arg(1))
+		if err != nil {
+			log.Fatal("validating helm charts: ", err)
+		}
+		return
+	default:
+		log.Fatal("unknown command: ", flag.Arg(0))
+	}
 
 	interval, err := time.ParseDuration(config.Config.PullInterval)
 	if err != nil {
 		log.Fatal("parsing pull interval: ", err)
 	}
 
+	if config.Config.Debounce != "" {
+		debounce, err = time.ParseDuration(config.Config.Debounce)
+		if err != nil {
+			log.Fatal("parsing debounce: ", err)
+		}
+	}
+	if config.Config.MinCommitAge != "" {
+		minCommitAge, err = time.ParseDuration(config.Config.MinCommitAge)
+		if err != nil {
+			log.Fatal("parsing min commit age: ", err)
+		}
+	}
+
+	err = freeze.Init()
+	if err != nil {
+		log.Fatal("parsing freezes: ", err)
+	}
+
+	err = git.GC(config.Config.RepoUrl, config.Config.Branch)
+	if err != nil {
+		slog.Error("removing orphaned clones", "err", err)
+	}
+
+	http.HandleFunc("/rollback", health.Authenticated(rollbackHandler))
 	health.Init()
 	for {
 		start := time.Now()
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"sinanmohd.com/scid/internal/config"
+	"sinanmohd.com/scid/internal/driver"
+	"sinanmohd.com/scid/internal/git"
+)
+
+// redeploys ref, a commit or run:<id>, and pins the branch HEAD so the
+// loop does not deploy it again until the branch moves on
+func rollback(ref string) error {
+	unlock, err := git.Lock(config.Config.RepoUrl, config.Config.Branch)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	g, err := git.Rollback(config.Config.RepoUrl, config.Config.Branch, ref)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := g.Cleanup()
+		if err != nil {
+			slog.Error("removing rollback checkout", "path", g.LocalPath, "err", err)
+		}
+	}()
+
+	slog.Warn("rolling back", "oldHash", g.OldHash, "newHash", g.NewHash)
+	err = driverRunIn(g)
+	if err != nil {
+		return err
+	}
+	if config.Config.DryRun {
+		return nil
+	}
+
+	runID, err := g.MarkDeployed()
+	if err != nil {
+		return err
+	}
+	slog.Info("run recorded", "runID", runID, "hash", g.NewHash)
+
+	return driver.NotifyRollback(g, runID)
+}
+
+// POST /rollback?to=<commit|run:id>, responds once the rollback is done
+func rollbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ref := r.URL.Query().Get("to")
+	if ref == "" {
+		http.Error(w, "missing to", http.StatusBadRequest)
+		return
+	}
+
+	slog.Warn("rollback requested", "to", ref, "remoteAddr", r.RemoteAddr)
+	err := rollback(ref)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "rolled back to %s\n", ref)
+}
@@ -3,7 +3,9 @@ package config
 import (
 	"errors"
 	"flag"
+	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/BurntSushi/toml"
 	"github.com/go-playground/validator/v10"
@@ -25,34 +27,205 @@ type TagModel string
 const (
 	// get static tag, tag name in Tag.Value
 	TagModelStatic TagModel = "static"
-	// get latest semver, Tag.Value ignored
+	// get latest semver matching Tag.Constraint, Tag.Value ignored
 	TagModelSemver TagModel = "semver"
+	// newest tag matching the glob in Tag.Value, by tagger or commit date
+	TagModelGlob TagModel = "glob"
+	// newest tag, Tag.Value ignored
+	TagModelLatest TagModel = "latest"
 	// tag disabled, Tag.Value ignored
 	TagModelDisabled TagModel = "disabled"
 )
 
+type TagMovePolicy string
+
+const (
+	// deploy the new commit of a force moved static tag
+	TagMoveDeploy TagMovePolicy = "deploy"
+	// keep the old commit and fail until the tag is moved back
+	TagMoveRefuse TagMovePolicy = "refuse"
+)
+
+// only tags reachable from Branch are considered, except for TagModelStatic
 type Tag struct {
 	Model TagModel `toml:"model" validate:"required"`
 	Value string   `toml:"value"`
+
+	// semver only, eg: api/ for api/v1.4.0, the v is optional
+	Prefix string `toml:"tag_prefix"`
+	// semver only, eg: ">=1.4 <2", "~1.4", "^1 || ^2"
+	Constraint      string `toml:"constraint"`
+	AllowPrerelease bool   `toml:"allow_prerelease"`
+
+	// static only, defaults to deploy
+	OnMove TagMovePolicy `toml:"on_move" validate:"omitempty,oneof=deploy refuse"`
+}
+
+// cluster helm deploys to, anything unset is left to helm's environment
+type Kube struct {
+	Kubeconfig  string `toml:"kubeconfig"`
+	KubeContext string `toml:"kube_context"`
+	KubeToken   string `toml:"kube_token"`
+}
+
+// charts are deployed to every cluster, each selecting its own env
+type HelmCluster struct {
+	EnvPriority []string `toml:"env_priority" validate:"required"`
+	Kube
 }
 
 type Helm struct {
+	// env priority and cluster of the unnamed cluster
 	EnvPriority []string `toml:"env_priority"`
-	ChartsPath  string   `toml:"charts_path" validate:"required"`
+	Kube
+
+	ChartsPath string `toml:"charts_path" validate:"required_without=ChartsPaths"`
+	// more roots, globs are expanded. a root matching nothing is an error
+	ChartsPaths []string `toml:"charts_paths"`
+	// directory levels searched below a root for scid.toml, 0 is unlimited
+	MaxDepth int `toml:"max_depth" validate:"gte=0"`
+	// path.Match patterns, against a directory name or its path below the root
+	Ignore []string `toml:"ignore"`
+	// helm uninstall releases scid deployed that are not declared anymore
+	Prune bool `toml:"prune"`
+
+	Clusters map[string]*HelmCluster `toml:"clusters" validate:"dive"`
+}
+
+func (h *Helm) Roots() []string {
+	if h.ChartsPath == "" {
+		return h.ChartsPaths
+	}
+	return append([]string{h.ChartsPath}, h.ChartsPaths...)
 }
 
 type SSHConfig struct {
-	KnownHosts string `toml:"known_hosts"`
+	// defaults to git
+	User string `toml:"user"`
+	// known_hosts content, KnownHostsFile and HostKeyFingerprints
+	// are also trusted if set
+	KnownHosts     string `toml:"known_hosts"`
+	KnownHostsFile string `toml:"known_hosts_file"`
+	// SHA256 fingerprints as printed by ssh-keygen -lf
+	HostKeyFingerprints []string `toml:"host_key_fingerprints"`
 	// any ssh key with pull access (eg: GitHub Deploy keys)
-	Key string `toml:"key"`
+	Key           string `toml:"key" validate:"required_without=Agent"`
+	KeyPassphrase string `toml:"key_passphrase"`
+	// use the keys from SSH_AUTH_SOCK instead of Key
+	Agent bool `toml:"agent"`
+}
+
+type HTTPSConfig struct {
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+	// bearer token, ignored if username is set
+	Token string `toml:"token"`
+	// executable printing a fresh token (or password if username is
+	// set) to stdout, invoked before every fetch
+	CredentialHelper []string `toml:"credential_helper"`
+}
+
+type VerifyConfig struct {
+	// armored OpenPGP public keys allowed to sign
+	GPGKeys string `toml:"gpg_keys" validate:"required_without=SSHKeys"`
+	// ssh public keys allowed to sign, one per line
+	// (authorized_keys or allowed_signers format)
+	SSHKeys string `toml:"ssh_keys"`
+}
+
+type HistoryRewritePolicy string
+
+const (
+	// keep failing on the old commit until someone intervenes
+	HistoryRewriteFail HistoryRewritePolicy = "fail"
+	// hard reset onto the rewritten remote branch
+	HistoryRewriteReset HistoryRewritePolicy = "reset"
+)
+
+type Submodules string
+
+const (
+	SubmodulesDisabled Submodules = ""
+	// only the submodules of the repo itself
+	SubmodulesEnabled Submodules = "true"
+	// submodules of submodules too
+	SubmodulesRecursive Submodules = "recursive"
+)
+
+// submodules = true | false | "recursive"
+func (s *Submodules) UnmarshalTOML(data any) error {
+	switch v := data.(type) {
+	case bool:
+		*s = SubmodulesDisabled
+		if v {
+			*s = SubmodulesEnabled
+		}
+	case string:
+		if v != string(SubmodulesRecursive) {
+			return fmt.Errorf("submodules: expected true, false or \"recursive\", got %q", v)
+		}
+		*s = SubmodulesRecursive
+	default:
+		return fmt.Errorf("submodules: expected true, false or \"recursive\", got %v", v)
+	}
+
+	return nil
+}
+
+type CloneConfig struct {
+	// shallow clone and fetch this many commits, 0 fetches everything
+	Depth int `toml:"depth" validate:"gte=0"`
+	// partial clone filter (eg: blob:limit=1m), omitted objects are never
+	// fetched later, so everything scid checks out must pass the filter
+	Filter string `toml:"filter"`
+	// only check out job watch paths, the helm charts paths with what the
+	// charts read (values, file:// dependencies, extra watch paths) and
+	// SparsePaths
+	Sparse      bool     `toml:"sparse"`
+	SparsePaths []string `toml:"sparse_paths"`
+	// check out submodules, changes inside them trigger jobs and charts
+	// watching the submodule path
+	Submodules Submodules `toml:"submodules"`
+}
+
+// holds back deploys of Targets, either from every Schedule match for
+// Duration or between Start and End
+type Freeze struct {
+	Name string `toml:"name" validate:"required"`
+	// cron expression: minute hour day-of-month month day-of-week
+	Schedule string `toml:"schedule" validate:"required_without=Start,excluded_with=Start"`
+	Duration string `toml:"duration" validate:"required_with=Schedule"`
+	// "2006-01-02 15:04" in TimeZone
+	Start string `toml:"start" validate:"required_with=End"`
+	End   string `toml:"end" validate:"required_with=Start"`
+	// IANA name (eg: Europe/Berlin), defaults to UTC
+	TimeZone string `toml:"time_zone"`
+	// job names and helm chart directory names, empty freezes everything
+	Targets []string `toml:"targets"`
 }
 
 type SCIDonfig struct {
-	Branch       string     `toml:"branch" validate:"required"`
-	RepoUrl      string     `toml:"repo_url" validate:"required"`
-	Tag          Tag        `toml:"tag"`
-	SSH          *SSHConfig `toml:"ssh"`
-	PullInterval string     `toml:"pull_interval"`
+	Branch       string       `toml:"branch" validate:"required"`
+	RepoUrl      string       `toml:"repo_url" validate:"required"`
+	Tag          Tag          `toml:"tag"`
+	SSH          *SSHConfig   `toml:"ssh"`
+	HTTPS        *HTTPSConfig `toml:"https"`
+	PullInterval string       `toml:"pull_interval"`
+	// clones and their metadata live here, defaults to the working directory
+	StateDir string      `toml:"state_dir"`
+	Clone    CloneConfig `toml:"clone"`
+	// deploy only once HEAD did not move for this long (eg: 30s)
+	Debounce string `toml:"debounce"`
+	// deploy only once HEAD was committed this long ago (eg: 2h)
+	MinCommitAge string `toml:"min_commit_age"`
+	// what to do when the branch is force pushed, defaults to fail
+	OnHistoryRewrite HistoryRewritePolicy `toml:"on_history_rewrite" validate:"omitempty,oneof=fail reset"`
+	// refuse to deploy commits (or tags) not signed by one of these keys
+	Verify *VerifyConfig `toml:"verify"`
+	Freeze []Freeze      `toml:"freeze" validate:"dive"`
+	// bearer token for the http api (freeze overrides, rollbacks),
+	// the api is disabled if unset
+	APIToken string `toml:"api_token"`
 
 	ForceReRun bool         `toml:"force_re_run"`
 	DryRun     bool         `toml:"dry_run"`
@@ -84,6 +257,7 @@ func Init() error {
 
 	Config = SCIDonfig{
 		PullInterval: "60s",
+		StateDir:     ".",
 		Tag: Tag{
 			Model: TagModelDisabled,
 		},
@@ -104,7 +278,7 @@ func Init() error {
 	flag.BoolVar(&Config.ForceReRun, "force-re-run", Config.DryRun, "Force Re Run")
 	flag.Parse()
 
-	err = subEnv(&Config)
+	err = SubEnv(&Config)
 	if err != nil {
 		return err
 	}
@@ -114,5 +288,11 @@ func Init() error {
 		return err
 	}
 
+	// drivers run in the clone, relative paths would resolve there
+	Config.StateDir, err = filepath.Abs(Config.StateDir)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
@@ -9,7 +9,8 @@ import (
 	"strings"
 )
 
-func subEnv(structVal any) error {
+// also used for config decoded outside of Init, like scid.toml
+func SubEnv(structVal any) error {
 	return subEnvStruct(reflect.ValueOf(structVal).Elem())
 }
 
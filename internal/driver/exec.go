@@ -3,34 +3,155 @@ package driver
 import (
 	"log/slog"
 	"os/exec"
+	"slices"
+	"sync"
 	"time"
 
 	"sinanmohd.com/scid/internal/config"
+	"sinanmohd.com/scid/internal/freeze"
 	"sinanmohd.com/scid/internal/git"
 )
 
-func ExecIfChaged(title string, paths, execLine []string, g *git.Git) (string, string, error /* exec error */, error) {
+// changed paths of targets deployed because a freeze held them back,
+// or because scid has no record of deploying them
+const (
+	freezeQueuedPath = "/freeze-queued"
+	notDeployedPath  = "/not-deployed"
+)
+
+// targets skipped by commit directives during a run, notified together
+var (
+	skippedMu      sync.Mutex
+	skippedTargets = make(map[string]string)
+)
+
+func skippedRecord(title, directive string) {
+	skippedMu.Lock()
+	defer skippedMu.Unlock()
+	skippedTargets[title] = directive
+}
+
+// directive forcing a target known by any of names
+func targetForced(g *git.Git, names []string) string {
+	for _, name := range names {
+		forced := g.Forced(name)
+		if forced != "" {
+			return forced
+		}
+	}
+	return ""
+}
+
+func targetSkipped(g *git.Git, names []string) string {
+	if targetForced(g, names) != "" {
+		return ""
+	}
+	for _, name := range names {
+		skipped := g.Skipped(name)
+		if skipped != "" {
+			return skipped
+		}
+	}
+	return ""
+}
+
+// why title has to run, "" if it does not. freezes and directives match
+// aliases too, eg: the chart directory name of chart@cluster
+func changedFor(title string, aliases, paths []string, deployed bool, g *git.Git) (string, error) {
+	names := append([]string{title}, aliases...)
 	var changed string
-	if g.OldHash == nil {
+	if targetForced(g, names) != "" {
+		changed = "/scid-force"
+	} else if g.OldHash == nil {
 		changed = "/"
+	} else if !deployed {
+		changed = notDeployedPath
 	} else {
 		changed = g.ArePathsChanged(paths)
 	}
+	if skipped := targetSkipped(g, names); changed != "" && skipped != "" {
+		slog.Info("skipped by commit directive", "title", title, "directive", skipped, "changed", changed)
+		skippedRecord(title, skipped)
+		changed = ""
+	}
+
+	// rollbacks neither touch nor honour the freeze queue
+	var isQueued bool
+	if !g.Rollback {
+		queued, err := g.Queued()
+		if err != nil {
+			return "", err
+		}
+		isQueued = slices.Contains(queued, title)
+	}
+	if changed == "" && isQueued {
+		changed = freezeQueuedPath
+	}
+
 	if changed == "" {
-		slog.Info("watch paths did not change, skipping", "title", title, "execLine", execLine)
-		return "", "", nil, nil
-	} else {
-		slog.Info("watch path changed, starting", "title", title, "execLine", execLine, "changed", changed)
+		slog.Info("watch paths did not change, skipping", "title", title)
+		return "", nil
+	}
+	window := freeze.Active(names, time.Now())
+	if window != nil && !g.Rollback {
+		slog.Info("frozen, queued until the freeze ends", "title", title, "freeze", window.Name, "end", window.End, "changed", changed)
+		if config.Config.DryRun {
+			return "", nil
+		}
+		return "", g.Queue(title)
 	}
 
+	if isQueued && !config.Config.DryRun {
+		err := g.Dequeue(title)
+		if err != nil {
+			return "", err
+		}
+	}
+	return changed, nil
+}
+
+// titles changedFor can be called with and their aliases, relative to
+// the repo root
+func Targets() (map[string][]string, error) {
+	targets := make(map[string][]string)
+	for name := range config.Config.Jobs {
+		targets[name] = nil
+	}
+	if config.Config.Helm != nil {
+		scidTomls, err := scidConfGet(config.Config.Helm)
+		if err != nil {
+			return nil, err
+		}
+		for name, scidToml := range scidTomls {
+			targets[name] = helmAliases(scidToml)
+		}
+	}
+
+	return targets, nil
+}
+
+// env nil inherits scid's environment
+func execRun(execLine, env []string) (string, error) {
 	if config.Config.DryRun {
 		time.Sleep(time.Second)
-		return "", changed, nil, nil
+		return "", nil
 	}
 
-	output, err := exec.Command(execLine[0], execLine[1:]...).CombinedOutput()
+	cmd := exec.Command(execLine[0], execLine[1:]...)
+	cmd.Env = env
+	output, err := cmd.CombinedOutput()
+	return string(output), err
+}
+
+func ExecIfChaged(title string, paths, execLine []string, g *git.Git) (string, string, error /* exec error */, error) {
+	changed, err := changedFor(title, nil, paths, true, g)
 	if err != nil {
-		return string(output), changed, err, nil
+		return "", "", nil, err
+	} else if changed == "" {
+		return "", "", nil, nil
 	}
-	return string(output), changed, nil, nil
+	slog.Info("watch path changed, starting", "title", title, "execLine", execLine, "changed", changed)
+
+	output, execErr := execRun(execLine, nil)
+	return output, changed, execErr, nil
 }
@@ -1,10 +1,16 @@
 package driver
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 
 	"sinanmohd.com/scid/internal/config"
@@ -22,8 +28,9 @@ const (
 )
 
 type scidHelmConf struct {
-	Version string                     `toml:"version"`
-	Env     map[string]scidHelmConfEnv `toml:"env"`
+	Version string `toml:"version"`
+	// decoded into scidHelmConfEnv once extends are merged
+	Env map[string]map[string]any `toml:"env"`
 }
 
 type scidHelmConfEnv struct {
@@ -35,86 +42,331 @@ type scidHelmConfEnv struct {
 	SopsValuePaths     []string `toml:"sops_value_paths"`
 	Dependencies       []string `toml:"dependencies"`
 
+	// remote chart, oci://registry/charts/app or a chart name in Repo.
+	// the directory only holds scid.toml and values then
+	Chart   string `toml:"chart" validate:"excluded_with=ChartPathOverride"`
+	Version string `toml:"version" validate:"required_with=Chart"`
+	Repo    string `toml:"repo" validate:"omitempty,url,excluded_without=Chart"`
+	// oci registries without tls, like a local one
+	PlainHTTP bool `toml:"plain_http" validate:"excluded_without=Chart"`
+
+	// more paths that trigger an upgrade, relative to the chart directory
+	ExtraWatchPaths []string `toml:"extra_watch_paths"`
+
+	// overrides the cluster's, like kube_context
+	config.Kube
+
+	// helm upgrade flags, wait and create_namespace default to true.
+	// on_failure rollbacks wait like the upgrade
+	Atomic          bool   `toml:"atomic"`
+	Timeout         string `toml:"timeout"`
+	Wait            *bool  `toml:"wait"`
+	WaitForJobs     bool   `toml:"wait_for_jobs"`
+	Force           bool   `toml:"force"`
+	HistoryMax      *int   `toml:"history_max" validate:"omitempty,gte=0"`
+	CreateNamespace *bool  `toml:"create_namespace"`
+	// "rollback" to the last deployed revision, atomic does that already
+	OnFailure string `toml:"on_failure" validate:"omitempty,oneof=rollback,excluded_with=Atomic"`
+
 	chartPath string
+	// path below its charts root, what Dependencies refer to
+	chart   string
+	cluster string
+	// chart and cluster, unique across clusters
+	name string
+	// selected env, and what it resolved to with extends merged
+	env      string
+	resolved map[string]any
+	// helm upgrade succeeded this run
+	upgraded bool
 }
 
-func HelmChartUpstallIfChaged(scidToml *scidHelmConfEnv, bg *git.Git) error {
+type helmRevision struct {
+	Revision int    `json:"revision"`
+	Status   string `json:"status"`
+}
+
+// shared by upgrade and rollback
+func helmWaitFlags(scidToml *scidHelmConfEnv) []string {
+	var flags []string
+	if scidToml.Wait == nil || *scidToml.Wait {
+		flags = append(flags, "--wait")
+	}
+	if scidToml.WaitForJobs {
+		flags = append(flags, "--wait-for-jobs")
+	}
+	if scidToml.Timeout != "" {
+		flags = append(flags, "--timeout", scidToml.Timeout)
+	}
+
+	return flags
+}
+
+func helmUpgradeFlags(scidToml *scidHelmConfEnv) []string {
+	flags := helmWaitFlags(scidToml)
+	if scidToml.Atomic {
+		flags = append(flags, "--atomic")
+	}
+	if scidToml.Force {
+		flags = append(flags, "--force")
+	}
+	if scidToml.HistoryMax != nil {
+		flags = append(flags, "--history-max", strconv.Itoa(*scidToml.HistoryMax))
+	}
+	if scidToml.CreateNamespace == nil || *scidToml.CreateNamespace {
+		flags = append(flags, "--create-namespace")
+	}
+
+	return flags
+}
+
+// rolls a failed upgrade back to the last deployed revision, describing
+// both revisions for the notification
+func helmRollback(scidToml *scidHelmConfEnv) (string, error) {
+	output, err := helmCommand(
+		scidToml.Kube,
+		"history", scidToml.ReleaseName,
+		"--namespace", scidToml.NameSpace,
+		"--output", "json",
+	).Output()
+	if err != nil {
+		return "", fmt.Errorf("getting helm history: %w", err)
+	}
+	var history []helmRevision
+	err = json.Unmarshal(output, &history)
+	if err != nil {
+		return "", err
+	}
+	if len(history) == 0 {
+		return "", fmt.Errorf("no helm history for %s", scidToml.ReleaseName)
+	}
+
+	failed := history[len(history)-1]
+	var target *helmRevision
+	for i := len(history) - 2; i >= 0; i-- {
+		if history[i].Status == "deployed" || history[i].Status == "superseded" {
+			target = &history[i]
+			break
+		}
+	}
+	if target == nil {
+		return fmt.Sprintf("revision %d failed, no previous revision to roll back to", failed.Revision), nil
+	}
+
 	execLine := []string{
-		"helm",
-		"upgrade",
-		"--install",
-		"--wait",
+		"helm", "rollback", scidToml.ReleaseName, strconv.Itoa(target.Revision),
 		"--namespace", scidToml.NameSpace,
-		"--create-namespace",
+	}
+	execLine = append(execLine, helmWaitFlags(scidToml)...)
+	slog.Warn("rolling back Helm release", "release", scidToml.ReleaseName, "failedRevision", failed.Revision, "revision", target.Revision)
+	output, err = helmCommand(scidToml.Kube, execLine[1:]...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("rolling back to revision %d: %w: %s", target.Revision, err, output)
+	}
+
+	return fmt.Sprintf("rolled back from failed revision %d to revision %d", failed.Revision, target.Revision), nil
+}
+
+// --values flags in helm's precedence order, cleanup removes the
+// decrypted sops files
+func helmValuesArgs(scidToml *scidHelmConfEnv) ([]string, func(), error) {
+	var args, plainPaths []string
+	cleanup := func() {
+		for _, path := range plainPaths {
+			os.Remove(path)
+		}
 	}
 
 	for _, path := range scidToml.ValuePaths {
 		fullPath := filepath.Join(scidToml.chartPath, path)
-		execLine = append(execLine, "--values", fullPath)
+		args = append(args, "--values", fullPath)
 	}
 
 	for _, path := range scidToml.OptionalValuePaths {
 		path, err := expandPath(path)
 		if err != nil {
-			return err
+			cleanup()
+			return nil, nil, err
 		}
 		_, err = os.Stat(path)
 		if err != nil {
 			continue
 		}
 
-		execLine = append(execLine, "--values", path)
+		args = append(args, "--values", path)
 	}
 
 	for _, encPath := range scidToml.SopsValuePaths {
 		fullEncPath := filepath.Join(scidToml.chartPath, encPath)
 		plainContent, err := decrypt.File(fullEncPath, "yaml")
 		if err != nil {
-			return err
+			cleanup()
+			return nil, nil, err
 		}
 
 		plainFile, err := os.CreateTemp("", "scid-helm-sops-enc-*.yaml")
 		if err != nil {
-			return err
+			cleanup()
+			return nil, nil, err
 		}
-		defer os.Remove(plainFile.Name())
+		plainPaths = append(plainPaths, plainFile.Name())
 
 		_, err = plainFile.WriteAt(plainContent, 0)
 		if err != nil {
-			return err
+			plainFile.Close()
+			cleanup()
+			return nil, nil, err
 		}
 		err = plainFile.Close()
 		if err != nil {
-			return err
+			cleanup()
+			return nil, nil, err
 		}
 
-		execLine = append(execLine, "--values", plainFile.Name())
+		args = append(args, "--values", plainFile.Name())
 	}
 
-	var finalChartPath string
-	if scidToml.ChartPathOverride == "" {
-		finalChartPath = scidToml.chartPath
-	} else {
-		finalChartPath = filepath.Join(scidToml.chartPath, scidToml.ChartPathOverride)
+	return args, cleanup, nil
+}
+
+// chart reference and flags locating it, shared by upgrade and template
+func helmChartArgs(scidToml *scidHelmConfEnv) []string {
+	if scidToml.Chart == "" && scidToml.ChartPathOverride == "" {
+		return []string{scidToml.chartPath}
+	} else if scidToml.Chart == "" {
+		return []string{filepath.Join(scidToml.chartPath, scidToml.ChartPathOverride)}
 	}
-	execLine = append(execLine, scidToml.ReleaseName, finalChartPath)
-	changeWatchPaths := []string{
-		scidToml.chartPath,
+
+	args := []string{scidToml.Chart, "--version", scidToml.Version}
+	if scidToml.Repo != "" {
+		args = append(args, "--repo", scidToml.Repo)
 	}
+	if scidToml.PlainHTTP {
+		args = append(args, "--plain-http")
+	}
+	return args
+}
 
-	output, changedPath, execErr, err := ExecIfChaged(filepath.Base(scidToml.chartPath), changeWatchPaths, execLine, bg)
+// every repo path feeding the release, paths outside the repo can not change
+func helmWatchPaths(scidToml *scidHelmConfEnv) ([]string, error) {
+	watchPaths := []string{scidToml.chartPath}
+	chartRelative := slices.Concat(scidToml.ValuePaths, scidToml.SopsValuePaths, scidToml.ExtraWatchPaths)
+	if scidToml.ChartPathOverride != "" {
+		chartRelative = append(chartRelative, scidToml.ChartPathOverride)
+	}
+	for _, path := range chartRelative {
+		watchPaths = append(watchPaths, filepath.Join(scidToml.chartPath, path))
+	}
+	for _, path := range scidToml.OptionalValuePaths {
+		path, err := expandPath(path)
+		if err != nil {
+			return nil, err
+		}
+		watchPaths = append(watchPaths, filepath.Clean(path))
+	}
+
+	if scidToml.Chart == "" {
+		dependencyDirs, err := helmLocalDependencies(helmChartArgs(scidToml)[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		watchPaths = append(watchPaths, dependencyDirs...)
+	}
+
+	return slices.DeleteFunc(watchPaths, func(path string) bool {
+		return !inRepo(path)
+	}), nil
+}
+
+// what every chart reads, for sparse checkouts. relative to the repo
+// root, the working directory
+func HelmSparsePaths(helm *config.Helm) ([]string, error) {
+	scidTomls, err := scidConfGet(helm)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, scidToml := range scidTomls {
+		watchPaths, err := helmWatchPaths(scidToml)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, watchPaths...)
+	}
+	return paths, nil
+}
+
+func HelmChartUpstallIfChaged(scidToml *scidHelmConfEnv, bg *git.Git) error {
+	chartName := scidToml.name
+	changeWatchPaths, err := helmWatchPaths(scidToml)
+	if err != nil {
+		return err
+	}
+	chartArgs := helmChartArgs(scidToml)
+	deployed, err := helmReleaseDeployed(scidToml, bg)
+	if err != nil {
+		return err
+	}
+	aliases := helmAliases(scidToml)
+	changedPath, err := changedFor(chartName, aliases, changeWatchPaths, deployed, bg)
 	if err != nil {
 		return err
 	} else if changedPath == "" {
 		return nil
 	}
 
-	title := fmt.Sprintf("Helm Chart %s", filepath.Base(scidToml.chartPath))
+	valuesArgs, cleanup, err := helmValuesArgs(scidToml)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if scidToml.Chart == "" {
+		cleanupDependencies, err := helmDependencyBuild(chartArgs[0])
+		defer cleanupDependencies()
+		if err != nil {
+			return err
+		}
+	}
+
+	execLine := []string{
+		"helm",
+		"upgrade",
+		"--install",
+		"--namespace", scidToml.NameSpace,
+	}
+	execLine = append(execLine, helmUpgradeFlags(scidToml)...)
+	execLine = append(execLine, valuesArgs...)
+	execLine = append(execLine, scidToml.ReleaseName)
+	execLine = append(execLine, chartArgs...)
+	slog.Info("watch path changed, starting", "title", chartName, "execLine", execLine, "changed", changedPath)
+
+	diff, err := helmDiff(scidToml, valuesArgs, chartArgs)
+	if err != nil {
+		slog.Warn("diffing Helm release", "release", scidToml.ReleaseName, "err", err)
+		diff = fmt.Sprintf("diff unavailable: %s", err)
+	}
+	if config.Config.DryRun {
+		slog.Info("plan for Helm Chart", "chart", chartName, "release", scidToml.ReleaseName, "namespace", scidToml.NameSpace, "diff", diff)
+	}
+
+	output, execErr := execRun(execLine, helmKubeEnv(scidToml.Kube))
+	title := fmt.Sprintf("Helm Chart %s", chartName)
+	if execErr != nil && scidToml.OnFailure == "rollback" {
+		rollback, err := helmRollback(scidToml)
+		if err != nil {
+			rollback = err.Error()
+		}
+		output = fmt.Sprintf("%s\n%s", output, rollback)
+	}
 	if execErr != nil {
-		description := fmt.Sprintf("watch path %s changed\n%s: %s", changedPath, execErr.Error(), output)
+		description := fmt.Sprintf("%s\ndiff:\n%s\n%s: %s", changeDescription(bg, append([]string{chartName}, aliases...), changedPath), diff, execErr.Error(), output)
 		err = notify(bg, helmColorHex, title, false, description)
 	} else {
-		description := fmt.Sprintf("watch path %s changed\n%s", changedPath, output)
+		// helmPrune records it, after the graph is done
+		scidToml.upgraded = true
+		description := fmt.Sprintf("%s\ndiff:\n%s\n%s", changeDescription(bg, append([]string{chartName}, aliases...), changedPath), diff, output)
 		err = notify(bg, helmColorHex, title, true, description)
 	}
 
@@ -181,30 +433,86 @@ func HelmChartUpstallGraph(dependencyGraph gograph.Graph[*scidHelmConfEnv], bg *
 	helmWg.Wait()
 }
 
-func scidConfGet(helm *config.Helm) (map[string]*scidHelmConfEnv, error) {
-	entries, err := os.ReadDir(helm.ChartsPath)
-	if err != nil {
-		return nil, err
+func helmIgnored(helm *config.Helm, name, relPath string) (bool, error) {
+	for _, pattern := range helm.Ignore {
+		for _, subject := range []string{name, relPath} {
+			ignored, err := path.Match(pattern, subject)
+			if err != nil || ignored {
+				return ignored, err
+			}
+		}
 	}
 
+	return false, nil
+}
+
+// directories with a scid.toml below root, by their slash separated path
+// relative to it. charts are not searched, anything in them is a subchart
+func helmChartsFind(helm *config.Helm, root string, chartPaths map[string]string) error {
 	configName := fmt.Sprintf("%s.toml", scidHelmConfigName)
-	scidHelmConfEnvs := make(map[string]*scidHelmConfEnv)
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			continue
+
+	return filepath.WalkDir(root, func(dirPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if !entry.IsDir() || dirPath == root {
+			return nil
 		}
 
-		chartPath := filepath.Join(helm.ChartsPath, entry.Name())
-		scidTomlPath := filepath.Join(chartPath, configName)
-		_, err := os.Stat(scidTomlPath)
+		relPath, err := filepath.Rel(root, dirPath)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		ignored, err := helmIgnored(helm, entry.Name(), relPath)
+		if err != nil {
+			return err
+		}
+		depth := strings.Count(relPath, "/") + 1
+		if ignored || strings.HasPrefix(entry.Name(), ".") || (helm.MaxDepth > 0 && depth > helm.MaxDepth) {
+			return filepath.SkipDir
+		}
+
+		_, err = os.Stat(filepath.Join(dirPath, configName))
 		if os.IsNotExist(err) {
-			continue
+			return nil
 		} else if err != nil {
-			return nil, err
+			return err
 		}
 
+		other, found := chartPaths[relPath]
+		if found {
+			return fmt.Errorf("charts %s and %s are both named %s", other, dirPath, relPath)
+		}
+		chartPaths[relPath] = dirPath
+		return filepath.SkipDir
+	})
+}
+
+func scidConfGet(helm *config.Helm) (map[string]*scidHelmConfEnv, error) {
+	chartPaths := make(map[string]string)
+	for _, pattern := range helm.Roots() {
+		roots, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("charts path %s: %w", pattern, err)
+		} else if len(roots) == 0 {
+			// a typo or rename would prune every release of it
+			return nil, fmt.Errorf("charts path %s matches nothing, remove it from the config if it has no charts anymore", pattern)
+		}
+
+		for _, root := range roots {
+			err = helmChartsFind(helm, root, chartPaths)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	configName := fmt.Sprintf("%s.toml", scidHelmConfigName)
+	scidHelmConfEnvs := make(map[string]*scidHelmConfEnv)
+	for chart, chartPath := range chartPaths {
+		scidTomlPath := filepath.Join(chartPath, configName)
 		var scidHelmConf scidHelmConf
-		_, err = toml.DecodeFile(scidTomlPath, &scidHelmConf)
+		_, err := toml.DecodeFile(scidTomlPath, &scidHelmConf)
 		if err != nil {
 			return nil, err
 		}
@@ -213,33 +521,73 @@ func scidConfGet(helm *config.Helm) (map[string]*scidHelmConfEnv, error) {
 			return nil, err
 		}
 
-		scidHelmConfEnv := new(scidHelmConfEnv)
-		var ok bool
-		for _, helmEnv := range helm.EnvPriority {
-			*scidHelmConfEnv, ok = scidHelmConf.Env[helmEnv]
-			if ok {
-				break
+		for _, cluster := range helmClusters(helm) {
+			scidHelmConfEnv, err := scidConfEnvSelect(&scidHelmConf, scidTomlPath, cluster)
+			if err != nil {
+				return nil, err
+			} else if scidHelmConfEnv == nil {
+				continue
 			}
-		}
-		if !ok {
-			continue
-		}
 
-		scidHelmConfEnv.chartPath = chartPath
-		scidHelmConfEnvs[entry.Name()] = scidHelmConfEnv
+			scidHelmConfEnv.chartPath = chartPath
+			scidHelmConfEnv.chart = chart
+			scidHelmConfEnv.name = helmVertexName(chart, cluster.name)
+			scidHelmConfEnvs[scidHelmConfEnv.name] = scidHelmConfEnv
+		}
 	}
 
 	return scidHelmConfEnvs, nil
 }
 
+// the first env of the cluster's priority the chart has, nil if none
+func scidConfEnvSelect(scidHelmConf *scidHelmConf, scidTomlPath string, cluster helmCluster) (*scidHelmConfEnv, error) {
+	index := slices.IndexFunc(cluster.envPriority, func(helmEnv string) bool {
+		_, ok := scidHelmConf.Env[helmEnv]
+		return ok
+	})
+	if index < 0 {
+		return nil, nil
+	}
+	env := cluster.envPriority[index]
+	resolved, err := helmEnvResolve(scidHelmConf.Env, env, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", scidTomlPath, err)
+	}
+	scidHelmConfEnv := &scidHelmConfEnv{
+		cluster:  cluster.name,
+		env:      env,
+		resolved: resolved,
+	}
+	unknown, err := helmEnvDecode(resolved, scidHelmConfEnv)
+	if err != nil {
+		return nil, fmt.Errorf("%s: env %s: %w", scidTomlPath, env, err)
+	} else if len(unknown) > 0 {
+		slog.Warn("unknown keys in scid.toml", "path", scidTomlPath, "env", env, "keys", unknown)
+	}
+	// map values are not validated with the rest
+	err = validator.New().Struct(scidHelmConfEnv)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", scidTomlPath, err)
+	}
+	if scidHelmConfEnv.Repo != "" && strings.HasPrefix(scidHelmConfEnv.Chart, "oci://") {
+		return nil, fmt.Errorf("%s: repo can not be used with oci charts", scidTomlPath)
+	}
+
+	scidHelmConfEnv.Kube, err = helmKube(cluster.kube, scidHelmConfEnv.Kube)
+	if err != nil {
+		return nil, fmt.Errorf("%s: env %s: %w", scidTomlPath, env, err)
+	}
+	return scidHelmConfEnv, nil
+}
+
 func helmDependencyGraph(scidTomls map[string]*scidHelmConfEnv) (gograph.Graph[*scidHelmConfEnv], error) {
 	dependencyGraph := gograph.New[*scidHelmConfEnv](gograph.Acyclic())
 	for name, scidToml := range scidTomls {
 		dependencyGraph.AddVertex(gograph.NewVertex(scidToml))
 		for _, dependencyName := range scidToml.Dependencies {
-			dependency, ok := scidTomls[dependencyName]
+			dependency, ok := scidTomls[helmVertexName(dependencyName, scidToml.cluster)]
 			if !ok {
-				return nil, fmt.Errorf("did not find dependency %s", dependencyName)
+				return nil, fmt.Errorf("did not find dependency %s of %s", dependencyName, name)
 			}
 
 			_, err := dependencyGraph.AddEdge(
@@ -267,5 +615,5 @@ func HelmChartsHandle(helm *config.Helm, bg *git.Git) error {
 	}
 	HelmChartUpstallGraph(dependencyGraph, bg)
 
-	return nil
+	return helmPrune(helm, scidHelmConfEnv, bg)
 }
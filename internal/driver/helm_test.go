@@ -0,0 +1,97 @@
+package driver
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestHelmChartArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		scidToml scidHelmConfEnv
+		want     []string
+	}{
+		{
+			"local",
+			scidHelmConfEnv{chartPath: "charts/app"},
+			[]string{"charts/app"},
+		},
+		{
+			"path override",
+			scidHelmConfEnv{chartPath: "charts/app", ChartPathOverride: "../shared/chart"},
+			[]string{"charts/shared/chart"},
+		},
+		{
+			"repo",
+			scidHelmConfEnv{chartPath: "charts/app", Chart: "nginx", Version: "1.2.3", Repo: "https://charts.example.com"},
+			[]string{"nginx", "--version", "1.2.3", "--repo", "https://charts.example.com"},
+		},
+		{
+			"oci",
+			scidHelmConfEnv{chartPath: "charts/app", Chart: "oci://registry.example.com/charts/app", Version: "0.1.0"},
+			[]string{"oci://registry.example.com/charts/app", "--version", "0.1.0"},
+		},
+		{
+			"oci plain http",
+			scidHelmConfEnv{chartPath: "charts/app", Chart: "oci://localhost:5000/app", Version: "0.1.0", PlainHTTP: true},
+			[]string{"oci://localhost:5000/app", "--version", "0.1.0", "--plain-http"},
+		},
+	}
+
+	for _, test := range tests {
+		got := helmChartArgs(&test.scidToml)
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%s: helmChartArgs = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestScidConfEnvSelectRemoteChart(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]any
+		wantErr bool
+	}{
+		{"repo", map[string]any{"chart": "nginx", "version": "1.2.3", "repo": "https://charts.example.com"}, false},
+		{"oci", map[string]any{"chart": "oci://registry.example.com/app", "version": "0.1.0", "plain_http": true}, false},
+		{"no version", map[string]any{"chart": "oci://registry.example.com/app"}, true},
+		{"repo with oci", map[string]any{"chart": "oci://registry.example.com/app", "version": "0.1.0", "repo": "https://charts.example.com"}, true},
+		{"repo without chart", map[string]any{"repo": "https://charts.example.com"}, true},
+		{"plain http without chart", map[string]any{"plain_http": true}, true},
+		{"chart with path override", map[string]any{"chart": "nginx", "version": "1.2.3", "chart_path_override": "chart"}, true},
+		{"repo not a url", map[string]any{"chart": "nginx", "version": "1.2.3", "repo": "charts"}, true},
+	}
+
+	for _, test := range tests {
+		test.env["release_name"] = "app"
+		test.env["namespace"] = "default"
+		scidHelmConf := &scidHelmConf{Env: map[string]map[string]any{"prod": test.env}}
+		_, err := scidConfEnvSelect(scidHelmConf, "scid.toml", helmCluster{envPriority: []string{"prod"}})
+		if test.wantErr && err == nil {
+			t.Errorf("%s: accepted", test.name)
+		} else if !test.wantErr && err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		}
+	}
+}
+
+func TestHelmWaitFlags(t *testing.T) {
+	no := false
+	tests := []struct {
+		name     string
+		scidToml scidHelmConfEnv
+		want     []string
+	}{
+		{"defaults", scidHelmConfEnv{}, []string{"--wait"}},
+		{"no wait", scidHelmConfEnv{Wait: &no}, nil},
+		{"no wait with timeout", scidHelmConfEnv{Wait: &no, Timeout: "2m"}, []string{"--timeout", "2m"}},
+		{"jobs and timeout", scidHelmConfEnv{WaitForJobs: true, Timeout: "10m"}, []string{"--wait", "--wait-for-jobs", "--timeout", "10m"}},
+	}
+
+	for _, test := range tests {
+		got := helmWaitFlags(&test.scidToml)
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%s: helmWaitFlags = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package driver
+
+import (
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+
+	"sinanmohd.com/scid/internal/config"
+)
+
+type helmCluster struct {
+	// empty for the top level helm config
+	name        string
+	envPriority []string
+	kube        config.Kube
+}
+
+func helmClusters(helm *config.Helm) []helmCluster {
+	var clusters []helmCluster
+	if len(helm.EnvPriority) > 0 || len(helm.Clusters) == 0 {
+		clusters = append(clusters, helmCluster{
+			envPriority: helm.EnvPriority,
+			kube:        helm.Kube,
+		})
+	}
+	for _, name := range slices.Sorted(maps.Keys(helm.Clusters)) {
+		clusters = append(clusters, helmCluster{
+			name:        name,
+			envPriority: helm.Clusters[name].EnvPriority,
+			kube:        helm.Clusters[name].Kube,
+		})
+	}
+
+	return clusters
+}
+
+// graph vertex of chart in cluster, Dependencies stay within a cluster
+func helmVertexName(chart, cluster string) string {
+	if cluster == "" {
+		return chart
+	}
+	return chart + "@" + cluster
+}
+
+// freezes and directives name charts by directory, in every cluster
+func helmAliases(scidToml *scidHelmConfEnv) []string {
+	if scidToml.chart == scidToml.name {
+		return nil
+	}
+	return []string{scidToml.chart}
+}
+
+// settings of the env win over the cluster's
+func helmKube(cluster, env config.Kube) (config.Kube, error) {
+	err := config.SubEnv(&env)
+	if err != nil {
+		return env, err
+	}
+
+	if env.Kubeconfig == "" {
+		env.Kubeconfig = cluster.Kubeconfig
+	}
+	if env.KubeContext == "" {
+		env.KubeContext = cluster.KubeContext
+	}
+	if env.KubeToken == "" {
+		env.KubeToken = cluster.KubeToken
+	}
+	if env.Kubeconfig != "" {
+		env.Kubeconfig, err = expandPath(env.Kubeconfig)
+	}
+
+	return env, err
+}
+
+// helm reads these, flags would leak the token into logs and ps
+func helmKubeEnv(kube config.Kube) []string {
+	if kube == (config.Kube{}) {
+		return nil
+	}
+
+	env := os.Environ()
+	if kube.Kubeconfig != "" {
+		env = append(env, "KUBECONFIG="+kube.Kubeconfig)
+	}
+	if kube.KubeContext != "" {
+		env = append(env, "HELM_KUBECONTEXT="+kube.KubeContext)
+	}
+	if kube.KubeToken != "" {
+		env = append(env, "HELM_KUBETOKEN="+kube.KubeToken)
+	}
+	return env
+}
+
+func helmCommand(kube config.Kube, args ...string) *exec.Cmd {
+	cmd := exec.Command("helm", args...)
+	cmd.Env = helmKubeEnv(kube)
+	return cmd
+}
@@ -0,0 +1,124 @@
+package driver
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+	"sinanmohd.com/scid/internal/config"
+)
+
+const helmCacheDirName = "helm-cache"
+
+// helm does not lock its repository cache
+var helmDependencyMu sync.Mutex
+
+type helmChartYaml struct {
+	Dependencies []struct {
+		Name       string `yaml:"name"`
+		Repository string `yaml:"repository"`
+	} `yaml:"dependencies"`
+}
+
+func helmChartDependencies(chartDir string) (*helmChartYaml, error) {
+	content, err := os.ReadFile(filepath.Join(chartDir, "Chart.yaml"))
+	if os.IsNotExist(err) {
+		return &helmChartYaml{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var chartYaml helmChartYaml
+	err = yaml.Unmarshal(content, &chartYaml)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Join(chartDir, "Chart.yaml"), err)
+	}
+	return &chartYaml, nil
+}
+
+// drivers run at the repo root, changed paths are relative to it
+func inRepo(path string) bool {
+	return !filepath.IsAbs(path) && path != ".." && !strings.HasPrefix(path, "../")
+}
+
+// directories of file:// dependencies inside the repo, recursively
+func helmLocalDependencies(chartDir string, seen []string) ([]string, error) {
+	chartYaml, err := helmChartDependencies(chartDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dependency := range chartYaml.Dependencies {
+		dependencyPath, ok := strings.CutPrefix(dependency.Repository, "file://")
+		if !ok {
+			continue
+		}
+		dependencyDir := filepath.Join(chartDir, dependencyPath)
+		if filepath.IsAbs(dependencyPath) || !inRepo(dependencyDir) {
+			slog.Warn("not watching Helm dependency outside the repo", "chart", chartDir, "dependency", dependency.Name)
+			continue
+		} else if slices.Contains(seen, dependencyDir) {
+			continue
+		}
+
+		seen = append(seen, dependencyDir)
+		seen, err = helmLocalDependencies(dependencyDir, seen)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return seen, nil
+}
+
+// builds the charts/ directory from Chart.yaml dependencies, cleanup
+// removes what was built so the worktree stays clean
+func helmDependencyBuild(chartDir string) (func(), error) {
+	cleanup := func() {}
+	chartYaml, err := helmChartDependencies(chartDir)
+	if err != nil || len(chartYaml.Dependencies) == 0 {
+		return cleanup, err
+	}
+
+	chartsDir := filepath.Join(chartDir, "charts")
+	lockPath := filepath.Join(chartDir, "Chart.lock")
+	existing, err := filepath.Glob(filepath.Join(chartsDir, "*"))
+	if err != nil {
+		return cleanup, err
+	}
+	_, err = os.Stat(lockPath)
+	if err == nil {
+		existing = append(existing, lockPath)
+	}
+	_, err = os.Stat(chartsDir)
+	if err == nil {
+		existing = append(existing, chartsDir)
+	}
+	cleanup = func() {
+		built, _ := filepath.Glob(filepath.Join(chartsDir, "*"))
+		for _, path := range append(built, lockPath, chartsDir) {
+			if !slices.Contains(existing, path) {
+				os.RemoveAll(path)
+			}
+		}
+	}
+
+	helmDependencyMu.Lock()
+	defer helmDependencyMu.Unlock()
+	slog.Info("building Helm chart dependencies", "chart", chartDir)
+	output, err := exec.Command(
+		"helm", "dependency", "build", chartDir,
+		"--repository-cache", filepath.Join(config.Config.StateDir, helmCacheDirName),
+	).CombinedOutput()
+	if err != nil {
+		return cleanup, fmt.Errorf("helm dependency build: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return cleanup, nil
+}
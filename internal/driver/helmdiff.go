@@ -0,0 +1,146 @@
+package driver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"sinanmohd.com/scid/internal/config"
+)
+
+// bigger resources are only reported as changed, the line diff is quadratic
+const helmDiffMaxLines = 8000
+
+func helmOutput(kube config.Kube, args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := helmCommand(kube, args...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("helm %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return string(output), nil
+}
+
+// normalized yaml lines by kind, namespace and name. hooks are skipped,
+// the release manifest does not have them
+func helmResources(manifest string) (map[string][]string, error) {
+	resources := make(map[string][]string)
+	decoder := yaml.NewDecoder(strings.NewReader(manifest))
+	for {
+		var doc map[string]any
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if doc == nil {
+			continue
+		}
+
+		kind, _ := doc["kind"].(string)
+		metadata, _ := doc["metadata"].(map[string]any)
+		name, _ := metadata["name"].(string)
+		namespace, _ := metadata["namespace"].(string)
+		annotations, _ := metadata["annotations"].(map[string]any)
+		if _, hook := annotations["helm.sh/hook"]; hook {
+			continue
+		}
+
+		key := kind + "/" + name
+		if namespace != "" {
+			key = kind + "/" + namespace + "/" + name
+		}
+		normalized, err := yaml.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		resources[key] = strings.Split(strings.TrimSpace(string(normalized)), "\n")
+	}
+
+	return resources, nil
+}
+
+// added and removed lines, from the longest common subsequence
+func lineDiff(old, new []string) (int, int) {
+	prev := make([]int, len(new)+1)
+	cur := make([]int, len(new)+1)
+	for i := range old {
+		for j := range new {
+			if old[i] == new[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(cur[j], prev[j+1])
+			}
+		}
+		prev, cur = cur, prev
+	}
+	common := prev[len(new)]
+
+	return len(new) - common, len(old) - common
+}
+
+// renders the chart like helm upgrade would and summarizes what changes
+// against the deployed release, one line per resource. no manifest content
+// is included, secrets end up in notifications otherwise
+func helmDiff(scidToml *scidHelmConfEnv, valuesArgs, chartArgs []string) (string, error) {
+	templateArgs := []string{"template", scidToml.ReleaseName}
+	templateArgs = append(templateArgs, chartArgs...)
+	templateArgs = append(templateArgs, "--namespace", scidToml.NameSpace)
+	rendered, err := helmOutput(scidToml.Kube, append(templateArgs, valuesArgs...)...)
+	if err != nil {
+		return "", err
+	}
+	deployed, err := helmOutput(scidToml.Kube, "get", "manifest", scidToml.ReleaseName, "--namespace", scidToml.NameSpace)
+	if err != nil && !strings.Contains(err.Error(), "release: not found") {
+		return "", err
+	}
+
+	newResources, err := helmResources(rendered)
+	if err != nil {
+		return "", err
+	}
+	oldResources, err := helmResources(deployed)
+	if err != nil {
+		return "", err
+	}
+
+	var keys []string
+	for key := range newResources {
+		keys = append(keys, key)
+	}
+	for key := range oldResources {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	keys = slices.Compact(keys)
+
+	var summary []string
+	for _, key := range keys {
+		oldLines, inOld := oldResources[key]
+		newLines, inNew := newResources[key]
+		switch {
+		case !inOld:
+			summary = append(summary, "+ "+key)
+		case !inNew:
+			summary = append(summary, "- "+key)
+		case slices.Equal(oldLines, newLines):
+		case len(oldLines)+len(newLines) > helmDiffMaxLines:
+			summary = append(summary, "~ "+key)
+		default:
+			added, removed := lineDiff(oldLines, newLines)
+			summary = append(summary, fmt.Sprintf("~ %s (+%d -%d)", key, added, removed))
+		}
+	}
+	if len(summary) == 0 {
+		return "no changes", nil
+	}
+
+	return strings.Join(summary, "\n"), nil
+}
@@ -0,0 +1,130 @@
+package driver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"sinanmohd.com/scid/internal/config"
+)
+
+// every env extends it, unless it extends another env
+const helmEnvDefault = "default"
+
+// lists are appended, tables merged, anything else in overlay replaces base
+func helmEnvMerge(base, overlay map[string]any) map[string]any {
+	merged := make(map[string]any)
+	for key, value := range base {
+		merged[key] = value
+	}
+
+	for key, value := range overlay {
+		switch value := value.(type) {
+		case []any:
+			baseList, _ := merged[key].([]any)
+			merged[key] = slices.Concat(baseList, value)
+		case map[string]any:
+			baseTable, _ := merged[key].(map[string]any)
+			merged[key] = helmEnvMerge(baseTable, value)
+		default:
+			merged[key] = value
+		}
+	}
+
+	return merged
+}
+
+// env name with everything it extends merged in, default first
+func helmEnvResolve(envs map[string]map[string]any, name string, seen []string) (map[string]any, error) {
+	env, ok := envs[name]
+	if !ok {
+		return nil, fmt.Errorf("env %s does not exist", name)
+	} else if slices.Contains(seen, name) {
+		return nil, fmt.Errorf("extends cycle: %s -> %s", strings.Join(seen, " -> "), name)
+	}
+	seen = append(seen, name)
+
+	extends, ok := env["extends"].(string)
+	if !ok && env["extends"] != nil {
+		return nil, fmt.Errorf("env %s: extends is not a string", name)
+	} else if !ok && name != helmEnvDefault {
+		_, ok = envs[helmEnvDefault]
+		if ok {
+			extends = helmEnvDefault
+		}
+	}
+
+	var base map[string]any
+	if extends != "" {
+		var err error
+		base, err = helmEnvResolve(envs, extends, seen)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resolved := helmEnvMerge(base, env)
+	delete(resolved, "extends")
+	return resolved, nil
+}
+
+// decodes a resolved env, returning keys scid does not know
+func helmEnvDecode(resolved map[string]any, scidToml *scidHelmConfEnv) ([]string, error) {
+	var buf bytes.Buffer
+	err := toml.NewEncoder(&buf).Encode(resolved)
+	if err != nil {
+		return nil, err
+	}
+	md, err := toml.Decode(buf.String(), scidToml)
+	if err != nil {
+		return nil, err
+	}
+
+	var unknown []string
+	for _, key := range md.Undecoded() {
+		unknown = append(unknown, key.String())
+	}
+	return unknown, nil
+}
+
+// resolves the charts like a run would and prints the selected envs,
+// only chartName if set, in every cluster unless it is chart@cluster
+func HelmChartsValidate(helm *config.Helm, chartName string, out io.Writer) error {
+	scidTomls, err := scidConfGet(helm)
+	if err != nil {
+		return err
+	}
+	_, err = helmDependencyGraph(scidTomls)
+	if err != nil {
+		return err
+	}
+
+	var found bool
+	for _, name := range slices.Sorted(maps.Keys(scidTomls)) {
+		scidToml := scidTomls[name]
+		if chartName != "" && name != chartName && scidToml.chart != chartName {
+			continue
+		}
+		found = true
+
+		fmt.Fprintf(out, "# %s, env %s", scidToml.chartPath, scidToml.env)
+		if scidToml.cluster != "" {
+			fmt.Fprintf(out, ", cluster %s", scidToml.cluster)
+		}
+		fmt.Fprintln(out)
+		err = toml.NewEncoder(out).Encode(map[string]any{name: scidToml.resolved})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out)
+	}
+	if chartName != "" && !found {
+		return fmt.Errorf("chart %s not found, or it has none of the envs it could be deployed with", chartName)
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package driver
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHelmEnvMerge(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    map[string]any
+		overlay map[string]any
+		want    map[string]any
+	}{
+		{
+			"nil base",
+			nil,
+			map[string]any{"namespace": "app"},
+			map[string]any{"namespace": "app"},
+		},
+		{
+			"scalar replaced",
+			map[string]any{"namespace": "app", "atomic": false},
+			map[string]any{"atomic": true},
+			map[string]any{"namespace": "app", "atomic": true},
+		},
+		{
+			"lists appended",
+			map[string]any{"value_paths": []any{"values.yaml"}},
+			map[string]any{"value_paths": []any{"prod.yaml"}},
+			map[string]any{"value_paths": []any{"values.yaml", "prod.yaml"}},
+		},
+		{
+			"list replaces scalar",
+			map[string]any{"value_paths": "values.yaml"},
+			map[string]any{"value_paths": []any{"prod.yaml"}},
+			map[string]any{"value_paths": []any{"prod.yaml"}},
+		},
+		{
+			"tables merged",
+			map[string]any{"set": map[string]any{"replicas": 1, "image": map[string]any{"tag": "v1", "pull": "always"}}},
+			map[string]any{"set": map[string]any{"image": map[string]any{"tag": "v2"}}},
+			map[string]any{"set": map[string]any{"replicas": 1, "image": map[string]any{"tag": "v2", "pull": "always"}}},
+		},
+	}
+
+	for _, test := range tests {
+		got := helmEnvMerge(test.base, test.overlay)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: helmEnvMerge = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestHelmEnvMergeKeepsBase(t *testing.T) {
+	base := map[string]any{"set": map[string]any{"replicas": 1}, "value_paths": []any{"values.yaml"}}
+	helmEnvMerge(base, map[string]any{"set": map[string]any{"replicas": 2}, "value_paths": []any{"prod.yaml"}})
+
+	want := map[string]any{"set": map[string]any{"replicas": 1}, "value_paths": []any{"values.yaml"}}
+	if !reflect.DeepEqual(base, want) {
+		t.Errorf("base changed to %v", base)
+	}
+}
+
+func TestHelmEnvResolve(t *testing.T) {
+	envs := map[string]map[string]any{
+		"default": {"namespace": "app", "value_paths": []any{"values.yaml"}},
+		"staging": {"value_paths": []any{"staging.yaml"}, "atomic": true},
+		"prod":    {"extends": "staging", "value_paths": []any{"prod.yaml"}, "atomic": false},
+		"eu":      {"extends": "prod", "namespace": "app-eu"},
+		"bare":    {"extends": "", "namespace": "bare"},
+		"bad":     {"extends": 1},
+		"missing": {"extends": "nope"},
+		"a":       {"extends": "b"},
+		"b":       {"extends": "c"},
+		"c":       {"extends": "a"},
+		"self":    {"extends": "self"},
+	}
+
+	tests := []struct {
+		name    string
+		env     string
+		want    map[string]any
+		wantErr string
+	}{
+		{"default", "default", map[string]any{"namespace": "app", "value_paths": []any{"values.yaml"}}, ""},
+		{
+			"implicit default", "staging",
+			map[string]any{"namespace": "app", "value_paths": []any{"values.yaml", "staging.yaml"}, "atomic": true}, "",
+		},
+		{
+			"chain", "eu",
+			map[string]any{"namespace": "app-eu", "value_paths": []any{"values.yaml", "staging.yaml", "prod.yaml"}, "atomic": false}, "",
+		},
+		{"empty extends", "bare", map[string]any{"namespace": "bare"}, ""},
+		{"extends not a string", "bad", nil, "not a string"},
+		{"extends missing env", "missing", nil, "env nope does not exist"},
+		{"unknown env", "dev", nil, "env dev does not exist"},
+		{"cycle", "a", nil, "extends cycle: a -> b -> c -> a"},
+		{"self cycle", "self", nil, "extends cycle: self -> self"},
+	}
+
+	for _, test := range tests {
+		got, err := helmEnvResolve(envs, test.env, nil)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: err = %v, want %q", test.name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: helmEnvResolve = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
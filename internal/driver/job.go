@@ -27,10 +27,10 @@ func JobRunIfChaged(name string, job config.JobConfig, g *git.Git) error {
 	}
 
 	if execErr != nil {
-		description := fmt.Sprintf("watch path %s changed\n%s: %s", changedPath, execErr.Error(), output)
+		description := fmt.Sprintf("%s\n%s: %s", changeDescription(g, []string{name}, changedPath), execErr.Error(), output)
 		err = notify(g, color, name, false, description)
 	} else {
-		description := fmt.Sprintf("watch path %s changed\n%s", changedPath, output)
+		description := fmt.Sprintf("%s\n%s", changeDescription(g, []string{name}, changedPath), output)
 		err = notify(g, color, name, true, description)
 	}
 	if err != nil {
@@ -0,0 +1,213 @@
+package driver
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"sinanmohd.com/scid/internal/config"
+	"sinanmohd.com/scid/internal/freeze"
+	"sinanmohd.com/scid/internal/git"
+)
+
+// kube settings only say how to reach the cluster, changing them does
+// not make it another release
+func sameRelease(a, b git.ManagedRelease) bool {
+	return a.Name == b.Name && a.Namespace == b.Namespace && a.Cluster == b.Cluster
+}
+
+func helmRelease(scidToml *scidHelmConfEnv) git.ManagedRelease {
+	var dependencies []string
+	for _, dependency := range scidToml.Dependencies {
+		dependencies = append(dependencies, helmVertexName(dependency, scidToml.cluster))
+	}
+
+	return git.ManagedRelease{
+		Chart:        scidToml.name,
+		ChartDir:     scidToml.chart,
+		Name:         scidToml.ReleaseName,
+		Namespace:    scidToml.NameSpace,
+		Dependencies: dependencies,
+		Cluster:      scidToml.cluster,
+		Kubeconfig:   scidToml.Kubeconfig,
+		KubeContext:  scidToml.KubeContext,
+	}
+}
+
+// false only for releases of a cluster added since, that scid did not
+// deploy yet. elsewhere changes alone decide
+func helmReleaseDeployed(scidToml *scidHelmConfEnv, g *git.Git) (bool, error) {
+	clusters, tracked, err := g.ManagedClusters()
+	if err != nil {
+		return false, err
+	} else if !tracked || slices.Contains(clusters, scidToml.cluster) {
+		return true, nil
+	}
+
+	managed, err := g.ManagedReleases()
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(managed, func(release git.ManagedRelease) bool {
+		return sameRelease(release, helmRelease(scidToml))
+	}), nil
+}
+
+func releaseKube(helm *config.Helm, release git.ManagedRelease) config.Kube {
+	kube := config.Kube{
+		Kubeconfig:  release.Kubeconfig,
+		KubeContext: release.KubeContext,
+	}
+	if release.Cluster == "" {
+		kube.KubeToken = helm.KubeToken
+	} else if cluster, ok := helm.Clusters[release.Cluster]; ok {
+		kube.KubeToken = cluster.KubeToken
+	}
+
+	return kube
+}
+
+// uninstalls dependents before their dependencies, returns the releases
+// that are still installed
+func helmUninstall(helm *config.Helm, removed []git.ManagedRelease) ([]git.ManagedRelease, []string, []string) {
+	var kept []git.ManagedRelease
+	var uninstalled, failed []string
+
+	remaining := slices.Clone(removed)
+	for len(remaining) > 0 {
+		// nothing left installed depends on it
+		index := slices.IndexFunc(remaining, func(release git.ManagedRelease) bool {
+			dependsOn := func(other git.ManagedRelease) bool {
+				return slices.Contains(other.Dependencies, release.Chart)
+			}
+			return !slices.ContainsFunc(remaining, dependsOn) && !slices.ContainsFunc(kept, dependsOn)
+		})
+		if index < 0 {
+			for _, release := range remaining {
+				slog.Warn("not pruning Helm release, a release depending on it is still installed", "release", release.Name, "namespace", release.Namespace)
+			}
+			kept = append(kept, remaining...)
+			break
+		}
+		release := remaining[index]
+		remaining = slices.Delete(remaining, index, index+1)
+		name := fmt.Sprintf("%s (%s)", release.Name, release.Namespace)
+		if release.Cluster != "" {
+			name = fmt.Sprintf("%s in %s", name, release.Cluster)
+		}
+
+		window := freeze.Active([]string{release.Chart, release.ChartDir}, time.Now())
+		if window != nil {
+			slog.Info("frozen, not pruning Helm release", "release", release.Name, "namespace", release.Namespace, "freeze", window.Name)
+			kept = append(kept, release)
+			continue
+		}
+		if config.Config.DryRun {
+			slog.Info("plan: uninstall Helm release", "release", release.Name, "namespace", release.Namespace, "cluster", release.Cluster, "chart", release.Chart)
+			kept = append(kept, release)
+			continue
+		}
+
+		slog.Info("pruning Helm release", "release", release.Name, "namespace", release.Namespace)
+		output, err := helmCommand(
+			releaseKube(helm, release),
+			"uninstall", release.Name,
+			"--namespace", release.Namespace,
+			"--wait",
+		).CombinedOutput()
+		if err != nil {
+			slog.Error("pruning Helm release", "release", release.Name, "namespace", release.Namespace, "err", err, "output", string(output))
+			failed = append(failed, fmt.Sprintf("%s: %s: %s", name, err, strings.TrimSpace(string(output))))
+			kept = append(kept, release)
+			continue
+		}
+		uninstalled = append(uninstalled, name)
+	}
+
+	return kept, uninstalled, failed
+}
+
+// uninstalls releases from the last run that are not declared anymore,
+// rollbacks never prune
+func helmPrune(helm *config.Helm, scidTomls map[string]*scidHelmConfEnv, g *git.Git) error {
+	if g.Rollback {
+		return nil
+	}
+
+	managed, err := g.ManagedReleases()
+	if err != nil {
+		return err
+	}
+	clusters, tracked, err := g.ManagedClusters()
+	if err != nil {
+		return err
+	}
+
+	// only upgraded releases are recorded, the rest is not deployed yet.
+	// untracked metadata predates this, everything was deployed then
+	var declared, recorded []git.ManagedRelease
+	pending := make(map[string]bool)
+	for _, scidToml := range scidTomls {
+		release := helmRelease(scidToml)
+		declared = append(declared, release)
+		wasManaged := slices.ContainsFunc(managed, func(other git.ManagedRelease) bool {
+			return sameRelease(release, other)
+		})
+		if scidToml.upgraded || wasManaged || !tracked {
+			recorded = append(recorded, release)
+		} else {
+			pending[release.Cluster] = true
+		}
+	}
+	slices.SortFunc(recorded, func(a, b git.ManagedRelease) int {
+		return strings.Compare(a.Chart, b.Chart)
+	})
+
+	// a cluster stays new until all of its releases were deployed once
+	known := []string{}
+	for _, cluster := range helmClusters(helm) {
+		if slices.Contains(clusters, cluster.name) || !pending[cluster.name] {
+			known = append(known, cluster.name)
+		}
+	}
+
+	var removed []git.ManagedRelease
+	for _, release := range managed {
+		isDeclared := slices.ContainsFunc(declared, func(other git.ManagedRelease) bool {
+			return sameRelease(release, other)
+		})
+		if !isDeclared {
+			removed = append(removed, release)
+		}
+	}
+
+	kept := removed
+	if len(removed) > 0 && !helm.Prune {
+		for _, release := range removed {
+			slog.Warn("Helm release is not declared anymore, set prune to uninstall it", "release", release.Name, "namespace", release.Namespace)
+		}
+	} else if len(removed) > 0 {
+		var uninstalled, failed []string
+		kept, uninstalled, failed = helmUninstall(helm, removed)
+		if len(uninstalled) > 0 || len(failed) > 0 {
+			var sections []string
+			if len(uninstalled) > 0 {
+				sections = append(sections, "uninstalled releases not declared anymore:\n"+strings.Join(uninstalled, "\n"))
+			}
+			if len(failed) > 0 {
+				sections = append(sections, "failed to uninstall:\n"+strings.Join(failed, "\n"))
+			}
+			err = notify(g, helmColorHex, "Helm Prune", len(failed) == 0, strings.Join(sections, "\n"))
+			if err != nil {
+				slog.Error("notifying Helm prune", "err", err)
+			}
+		}
+	}
+
+	if config.Config.DryRun {
+		return nil
+	}
+	return g.ManagedReleasesSet(append(recorded, kept...), known)
+}
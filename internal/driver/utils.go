@@ -1,12 +1,17 @@
 package driver
 
 import (
+	"fmt"
 	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"sinanmohd.com/scid/internal/config"
+	"sinanmohd.com/scid/internal/freeze"
 	"sinanmohd.com/scid/internal/git"
 	"sinanmohd.com/scid/internal/slack"
 )
@@ -25,6 +30,80 @@ func notify(g *git.Git, color, title string, success bool, description string) e
 	}
 }
 
+// why target is deployed, shown in notifications
+func changeDescription(g *git.Git, names []string, changedPath string) string {
+	forced := targetForced(g, names)
+	if forced != "" {
+		return fmt.Sprintf("forced by %s", forced)
+	} else if changedPath == freezeQueuedPath {
+		return "held back by a freeze, deploying now"
+	} else if changedPath == notDeployedPath {
+		return "not deployed by scid yet"
+	}
+
+	return fmt.Sprintf("watch path %s changed", changedPath)
+}
+
+func NotifyUnverified(unverified *git.UnverifiedError) error {
+	g := &git.Git{
+		OldHash: unverified.OldHash,
+		NewHash: &unverified.Hash,
+	}
+	description := fmt.Sprintf("refusing to deploy\n%s", unverified.Err)
+	return notify(g, defaultColorHex, "Commit Signature", false, description)
+}
+
+func NotifyTagMoved(moved *git.TagMovedError) error {
+	g := &git.Git{
+		OldHash: &moved.OldHash,
+		NewHash: &moved.NewHash,
+	}
+	description := fmt.Sprintf("refusing to deploy force moved tag %s", moved.Tag)
+	return notify(g, defaultColorHex, "Tag "+moved.Tag, false, description)
+}
+
+func NotifyHistoryRewritten(g *git.Git) error {
+	description := fmt.Sprintf("branch %s was force pushed, deploying changes against the previous tree", config.Config.Branch)
+	return notify(g, defaultColorHex, "History Rewrite", true, description)
+}
+
+func NotifyRollback(g *git.Git, runID int) error {
+	description := fmt.Sprintf("rolled back as run %d, the branch stays pinned until it moves on", runID)
+	return notify(g, defaultColorHex, "Rollback", true, description)
+}
+
+func NotifyFreeze(g *git.Git, window *freeze.Window, started bool) error {
+	targets := "everything"
+	if len(window.Targets) > 0 {
+		targets = strings.Join(window.Targets, ", ")
+	}
+
+	var description string
+	if started {
+		description = fmt.Sprintf("freeze started, holding back %s until %s", targets, window.End.Format(time.RFC1123))
+	} else {
+		description = fmt.Sprintf("freeze ended, deploying held back changes of %s", targets)
+	}
+	return notify(g, defaultColorHex, "Freeze "+window.Name, true, description)
+}
+
+// one notification for every target skipped since the last call
+func NotifySkipped(g *git.Git) error {
+	skippedMu.Lock()
+	targets := skippedTargets
+	skippedTargets = make(map[string]string)
+	skippedMu.Unlock()
+	if len(targets) == 0 {
+		return nil
+	}
+
+	description := "skipped by commit directives"
+	for _, target := range slices.Sorted(maps.Keys(targets)) {
+		description += fmt.Sprintf("\n%s: %s", target, targets[target])
+	}
+	return notify(g, defaultColorHex, "Skipped", true, description)
+}
+
 func expandPath(path string) (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
@@ -0,0 +1,153 @@
+package freeze
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type cronField struct {
+	min, max int
+	names    []string
+}
+
+var (
+	cronMinute = cronField{0, 59, nil}
+	cronHour   = cronField{0, 23, nil}
+	cronDom    = cronField{1, 31, nil}
+	cronMonth  = cronField{1, 12, []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	// 7 is sunday too
+	cronDow = cronField{0, 7, []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+type cron struct {
+	minute, hour, dom, month, dow [64]bool
+	// day-of-month and day-of-week are ORed if both are restricted, like
+	// vixie cron a field starting with * (eg: */2) is not restricted
+	domStar, dowStar bool
+}
+
+func (f cronField) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return f.min + i, nil
+		}
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < f.min || n > f.max {
+		return 0, fmt.Errorf("%q not in %d-%d", s, f.min, f.max)
+	}
+	return n, nil
+}
+
+// supports *, lists, ranges, steps and month or weekday names
+func (f cronField) parse(field string, set *[64]bool) error {
+	for part := range strings.SplitSeq(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return fmt.Errorf("invalid step %q", part)
+			}
+		}
+
+		low, high := f.min, f.max
+		if rangePart != "*" {
+			lowPart, highPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			low, err = f.value(lowPart)
+			if err != nil {
+				return err
+			}
+			high = low
+			if isRange {
+				high, err = f.value(highPart)
+				if err != nil {
+					return err
+				}
+			} else if hasStep {
+				high = f.max
+			}
+		}
+		if low > high {
+			return fmt.Errorf("invalid range %q", part)
+		}
+
+		for i := low; i <= high; i += step {
+			set[i] = true
+		}
+	}
+
+	return nil
+}
+
+func parseCron(expr string) (*cron, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q needs 5 fields", expr)
+	}
+
+	c := &cron{
+		domStar: strings.HasPrefix(fields[2], "*"),
+		dowStar: strings.HasPrefix(fields[4], "*"),
+	}
+	for i, field := range []struct {
+		cronField
+		set *[64]bool
+	}{
+		{cronMinute, &c.minute},
+		{cronHour, &c.hour},
+		{cronDom, &c.dom},
+		{cronMonth, &c.month},
+		{cronDow, &c.dow},
+	} {
+		err := field.parse(fields[i], field.set)
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %w", expr, err)
+		}
+	}
+	if c.dow[7] {
+		c.dow[0] = true
+	}
+
+	return c, nil
+}
+
+func (c *cron) dayMatch(t time.Time) bool {
+	if !c.month[t.Month()] {
+		return false
+	}
+
+	dom, dow := c.dom[t.Day()], c.dow[t.Weekday()]
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// latest match in (after, t], zero if there is none
+func (c *cron) prev(t, after time.Time) time.Time {
+	t = t.Truncate(time.Minute)
+	for t.After(after) {
+		if !c.dayMatch(t) {
+			// last minute of the previous day
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()).Add(-time.Minute)
+			continue
+		}
+		if !c.hour[t.Hour()] {
+			// last minute of the previous hour
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location()).Add(-time.Minute)
+			continue
+		}
+		if c.minute[t.Minute()] {
+			return t
+		}
+		t = t.Add(-time.Minute)
+	}
+
+	return time.Time{}
+}
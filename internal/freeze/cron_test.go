@@ -0,0 +1,151 @@
+package freeze
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		wantErr bool
+		// checked against the parsed cron if set
+		check func(c *cron) bool
+	}{
+		{"every minute", "* * * * *", false, func(c *cron) bool {
+			return c.minute[0] && c.minute[59] && c.domStar && c.dowStar
+		}},
+		{"step", "*/15 * * * *", false, func(c *cron) bool {
+			return c.minute[0] && c.minute[15] && c.minute[45] && !c.minute[50]
+		}},
+		{"list and range step", "0 0 1,5-9/2 * *", false, func(c *cron) bool {
+			return c.dom[1] && c.dom[5] && c.dom[7] && c.dom[9] && !c.dom[6] && !c.domStar
+		}},
+		{"step from", "0 0 10/10 * *", false, func(c *cron) bool {
+			return c.dom[10] && c.dom[20] && c.dom[30] && !c.dom[1]
+		}},
+		{"names", "0 0 * JAN,dec mon-fri", false, func(c *cron) bool {
+			return c.month[1] && c.month[12] && !c.month[6] &&
+				c.dow[1] && c.dow[5] && !c.dow[6] && !c.dow[0]
+		}},
+		{"sunday is 7", "0 0 * * 7", false, func(c *cron) bool {
+			return c.dow[0] && !c.dowStar
+		}},
+		{"starred step", "0 0 */2 * */3", false, func(c *cron) bool {
+			return c.domStar && c.dowStar && c.dom[1] && !c.dom[2] && c.dow[3]
+		}},
+		{"too few fields", "* * * *", true, nil},
+		{"too many fields", "* * * * * *", true, nil},
+		{"minute out of range", "60 * * * *", true, nil},
+		{"day zero", "0 0 0 * *", true, nil},
+		{"zero step", "*/0 * * * *", true, nil},
+		{"reverse range", "5-1 * * * *", true, nil},
+		{"unknown name", "0 0 * * someday", true, nil},
+	}
+
+	for _, test := range tests {
+		c, err := parseCron(test.expr)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: parseCron(%q) succeeded", test.name, test.expr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if !test.check(c) {
+			t.Errorf("%s: parseCron(%q) = %+v", test.name, test.expr, c)
+		}
+	}
+}
+
+func TestDayMatch(t *testing.T) {
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(2026, month, d, 12, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		day  time.Time
+		want bool
+	}{
+		{"starred dom needs both, monday odd", "0 0 */2 * 1", day(10, 19), true},
+		{"starred dom needs both, monday even", "0 0 */2 * 1", day(10, 26), false},
+		{"starred dom needs both, wednesday odd", "0 0 */2 * 1", day(10, 21), false},
+		{"starred dow needs both", "0 0 13 * */2", day(10, 13), true},
+		{"restricted are ORed, dom", "0 0 13 * fri", day(10, 13), true},
+		{"restricted are ORed, dow", "0 0 13 * fri", day(10, 16), true},
+		{"restricted are ORed, neither", "0 0 13 * fri", day(10, 14), false},
+		{"sunday as 7", "0 0 * * 7", day(10, 18), true},
+		{"last day of month", "0 0 31 * *", day(10, 31), true},
+		{"no 31st", "0 0 31 * *", day(11, 30), false},
+		{"other month", "0 0 * feb *", day(10, 18), false},
+	}
+
+	for _, test := range tests {
+		c, err := parseCron(test.expr)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		got := c.dayMatch(test.day)
+		if got != test.want {
+			t.Errorf("%s: %q dayMatch(%s) = %t, want %t", test.name, test.expr, test.day.Format(time.DateOnly), got, test.want)
+		}
+	}
+}
+
+func TestPrev(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	utc := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	est := time.FixedZone("EST", -5*60*60)
+
+	tests := []struct {
+		name  string
+		expr  string
+		t     time.Time
+		after time.Time
+		want  time.Time
+	}{
+		{"previous day", "0 22 * * *", utc(2026, 10, 18, 10, 0), utc(2026, 10, 17, 10, 0), utc(2026, 10, 17, 22, 0)},
+		{"at t", "0 22 * * *", utc(2026, 10, 18, 22, 0).Add(30 * time.Second), utc(2026, 10, 18, 0, 0), utc(2026, 10, 18, 22, 0)},
+		{"after is excluded", "0 22 * * *", utc(2026, 10, 18, 10, 0), utc(2026, 10, 17, 22, 0), time.Time{}},
+		{"none in range", "0 0 * * *", utc(2026, 10, 18, 10, 0), utc(2026, 10, 18, 1, 0), time.Time{}},
+		{"last day of month", "0 0 31 * *", utc(2026, 11, 15, 0, 0), utc(2026, 10, 1, 0, 0), utc(2026, 10, 31, 0, 0)},
+		{"skips short months", "0 0 31 * *", utc(2026, 5, 1, 0, 0), utc(2026, 1, 1, 0, 0), utc(2026, 3, 31, 0, 0)},
+		{"leap day", "59 23 28-31 feb *", utc(2028, 3, 1, 12, 0), utc(2028, 2, 1, 0, 0), utc(2028, 2, 29, 23, 59)},
+		{"starred dom", "0 0 */2 * 1", utc(2026, 10, 31, 0, 0), utc(2026, 10, 1, 0, 0), utc(2026, 10, 19, 0, 0)},
+		{
+			// 01:30 happens twice, the later one is in EST
+			"dst ends", "30 1 * * *",
+			time.Date(2026, 11, 1, 1, 45, 0, 0, est).In(newYork), utc(2026, 10, 31, 12, 0),
+			time.Date(2026, 11, 1, 1, 30, 0, 0, est),
+		},
+		{
+			// there is no 02:30 on the day dst starts
+			"dst starts", "30 2 * * *",
+			time.Date(2026, 3, 8, 12, 0, 0, 0, newYork), time.Date(2026, 3, 6, 12, 0, 0, 0, newYork),
+			time.Date(2026, 3, 7, 2, 30, 0, 0, newYork),
+		},
+	}
+
+	for _, test := range tests {
+		c, err := parseCron(test.expr)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		got := c.prev(test.t, test.after)
+		if !got.Equal(test.want) {
+			t.Errorf("%s: %q prev(%s, %s) = %s, want %s", test.name, test.expr, test.t, test.after, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package freeze
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	// time zones even on hosts without zoneinfo
+	_ "time/tzdata"
+
+	"sinanmohd.com/scid/internal/config"
+)
+
+const dateLayout = "2006-01-02 15:04"
+
+type Window struct {
+	Name       string
+	Start, End time.Time
+	// empty freezes everything
+	Targets []string
+}
+
+type freeze struct {
+	name     string
+	targets  []string
+	location *time.Location
+
+	cron     *cron
+	duration time.Duration
+
+	start, end time.Time
+}
+
+var (
+	freezesMu sync.Mutex
+	freezes   []*freeze
+	// freeze name to the start of its overridden window
+	overridden = make(map[string]time.Time)
+	// freeze name to its window last announced as started
+	announced = make(map[string]*Window)
+)
+
+func Init() error {
+	freezesMu.Lock()
+	defer freezesMu.Unlock()
+
+	freezes = nil
+	for _, freezeConfig := range config.Config.Freeze {
+		for _, f := range freezes {
+			if f.name == freezeConfig.Name {
+				return fmt.Errorf("duplicate freeze %s", f.name)
+			}
+		}
+
+		location, err := time.LoadLocation(freezeConfig.TimeZone)
+		if err != nil {
+			return fmt.Errorf("freeze %s: %w", freezeConfig.Name, err)
+		}
+		f := &freeze{
+			name:     freezeConfig.Name,
+			targets:  freezeConfig.Targets,
+			location: location,
+		}
+
+		if freezeConfig.Schedule != "" {
+			f.cron, err = parseCron(freezeConfig.Schedule)
+			if err != nil {
+				return fmt.Errorf("freeze %s: %w", f.name, err)
+			}
+			f.duration, err = time.ParseDuration(freezeConfig.Duration)
+			if err != nil {
+				return fmt.Errorf("freeze %s: %w", f.name, err)
+			}
+		} else {
+			f.start, err = time.ParseInLocation(dateLayout, freezeConfig.Start, location)
+			if err != nil {
+				return fmt.Errorf("freeze %s: %w", f.name, err)
+			}
+			f.end, err = time.ParseInLocation(dateLayout, freezeConfig.End, location)
+			if err != nil {
+				return fmt.Errorf("freeze %s: %w", f.name, err)
+			}
+			if !f.end.After(f.start) {
+				return fmt.Errorf("freeze %s: end is not after start", f.name)
+			}
+		}
+
+		freezes = append(freezes, f)
+	}
+
+	return nil
+}
+
+// the window containing now, overrides are not considered
+func (f *freeze) window(now time.Time) *Window {
+	now = now.In(f.location)
+	start, end := f.start, f.end
+	if f.cron != nil {
+		start = f.cron.prev(now, now.Add(-f.duration))
+		if start.IsZero() {
+			return nil
+		}
+		end = start.Add(f.duration)
+	} else if now.Before(start) || !now.Before(end) {
+		return nil
+	}
+
+	return &Window{
+		Name:    f.name,
+		Start:   start,
+		End:     end,
+		Targets: f.targets,
+	}
+}
+
+func (f *freeze) activeWindow(now time.Time) *Window {
+	window := f.window(now)
+	if window == nil {
+		return nil
+	}
+	overrideStart, found := overridden[f.name]
+	if found && overrideStart.Equal(window.Start) {
+		return nil
+	}
+
+	return window
+}
+
+func (f *freeze) holds(names []string) bool {
+	if len(f.targets) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(names, func(name string) bool {
+		return slices.Contains(f.targets, name)
+	})
+}
+
+// the window holding back a target known by any of names, nil if it can
+// be deployed
+func Active(names []string, now time.Time) *Window {
+	freezesMu.Lock()
+	defer freezesMu.Unlock()
+
+	for _, f := range freezes {
+		if !f.holds(names) {
+			continue
+		}
+		window := f.activeWindow(now)
+		if window != nil {
+			return window
+		}
+	}
+
+	return nil
+}
+
+// windows that started or ended (or got overridden) since the last call
+func Transitions(now time.Time) (started, ended []*Window) {
+	freezesMu.Lock()
+	defer freezesMu.Unlock()
+
+	for _, f := range freezes {
+		window := f.activeWindow(now)
+		last, found := announced[f.name]
+		if found && (window == nil || !window.Start.Equal(last.Start)) {
+			ended = append(ended, last)
+			delete(announced, f.name)
+		}
+		if window != nil && (!found || !window.Start.Equal(last.Start)) {
+			started = append(started, window)
+			announced[f.name] = window
+		}
+	}
+
+	return started, ended
+}
+
+// POST /freeze/override?name=<freeze>, lifts the current window of the
+// named freeze, or of every active one if name is omitted
+func OverrideHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	now := time.Now()
+	var names []string
+	freezesMu.Lock()
+	for _, f := range freezes {
+		if name != "" && f.name != name {
+			continue
+		}
+		window := f.activeWindow(now)
+		if window == nil {
+			continue
+		}
+
+		overridden[f.name] = window.Start
+		names = append(names, f.name)
+	}
+	freezesMu.Unlock()
+	if len(names) == 0 {
+		http.Error(w, "no active freeze", http.StatusNotFound)
+		return
+	}
+
+	slog.Warn("freeze overridden", "names", names, "remoteAddr", r.RemoteAddr)
+	fmt.Fprintf(w, "overridden %s\n", strings.Join(names, ", "))
+}
@@ -0,0 +1,176 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"github.com/go-git/go-git/v6/plumbing/storer"
+)
+
+const (
+	directiveSkipAll = "[skip scid]"
+	directiveSkip    = "Scid-Skip"
+	directiveForce   = "Scid-Force"
+
+	// bounds the walk when OldHash is not an ancestor anymore
+	directiveMaxCommits = 256
+)
+
+// directives of a commit, targets are job names or helm chart directory
+// names
+type commitDirectives struct {
+	skipAll     string
+	skip, force map[string]string
+}
+
+// of the commits between OldHash and NewHash, newest first
+type directives []commitDirectives
+
+func directiveDescribe(directive string, hash plumbing.Hash) string {
+	return fmt.Sprintf("%s (%s)", directive, hash.String()[:7])
+}
+
+func directivesParse(commit *object.Commit) commitDirectives {
+	d := commitDirectives{
+		skip:  make(map[string]string),
+		force: make(map[string]string),
+	}
+	for line := range strings.Lines(commit.Message) {
+		line = strings.TrimSpace(line)
+		if strings.Contains(strings.ToLower(line), directiveSkipAll) && d.skipAll == "" {
+			d.skipAll = directiveDescribe(directiveSkipAll, commit.Hash)
+		}
+
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		var targets map[string]string
+		switch {
+		case strings.EqualFold(key, directiveSkip):
+			targets, key = d.skip, directiveSkip
+		case strings.EqualFold(key, directiveForce):
+			targets, key = d.force, directiveForce
+		default:
+			continue
+		}
+
+		for target := range strings.FieldsFuncSeq(value, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		}) {
+			_, found := targets[target]
+			if !found {
+				targets[target] = directiveDescribe(key+": "+target, commit.Hash)
+			}
+		}
+	}
+
+	return d
+}
+
+// commits reachable from hash, to where a shallow clone ends
+func ancestorsOf(repo *git.Repository, hash plumbing.Hash) (map[plumbing.Hash]bool, error) {
+	ancestors := make(map[plumbing.Hash]bool)
+	commits, err := repo.Log(&git.LogOptions{From: hash})
+	if err != nil {
+		return nil, err
+	}
+	defer commits.Close()
+
+	err = commits.ForEach(func(commit *object.Commit) error {
+		ancestors[commit.Hash] = true
+		return nil
+	})
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		slog.Warn("commit history incomplete, directives of older commits might be seen again", "err", err)
+		return ancestors, nil
+	}
+	return ancestors, err
+}
+
+// commits reachable from newHash but not from oldHash, through every
+// parent so merged branches count too. only newHash if oldHash is nil
+func commitRange(repo *git.Repository, oldHash *plumbing.Hash, newHash plumbing.Hash) ([]*object.Commit, error) {
+	newCommit, err := repo.CommitObject(newHash)
+	if err != nil {
+		return nil, err
+	} else if oldHash == nil {
+		return []*object.Commit{newCommit}, nil
+	}
+
+	excluded, err := ancestorsOf(repo, *oldHash)
+	if err != nil {
+		return nil, err
+	}
+
+	var commits []*object.Commit
+	iter := object.NewCommitIterCTime(newCommit, excluded, nil)
+	defer iter.Close()
+	err = iter.ForEach(func(commit *object.Commit) error {
+		commits = append(commits, commit)
+		if len(commits) >= directiveMaxCommits {
+			return storer.ErrStop
+		}
+		return nil
+	})
+	// shallow clones end before oldHash
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		slog.Warn("commit history incomplete, directives might be missed", "err", err)
+		return commits, nil
+	}
+
+	return commits, err
+}
+
+func (g *Git) directivesSet() error {
+	commits, err := commitRange(g.repo, g.OldHash, *g.NewHash)
+	if err != nil {
+		return err
+	}
+
+	g.directives = nil
+	for _, commit := range commits {
+		g.directives = append(g.directives, directivesParse(commit))
+	}
+	return nil
+}
+
+// the directive skipping target, "" if it is not skipped. every commit of
+// the update has to skip it, other changes would never be deployed
+// otherwise. Scid-Force in any commit wins over Scid-Skip and [skip scid]
+func (g *Git) Skipped(target string) string {
+	if g.Forced(target) != "" || len(g.directives) == 0 {
+		return ""
+	}
+
+	var newest string
+	for _, d := range g.directives {
+		directive, found := d.skip[target]
+		if !found {
+			directive = d.skipAll
+		}
+		if directive == "" {
+			return ""
+		} else if newest == "" {
+			newest = directive
+		}
+	}
+	return newest
+}
+
+// the directive forcing target, "" if it is not forced. the newest one is
+// shown
+func (g *Git) Forced(target string) string {
+	for _, d := range g.directives {
+		directive, found := d.force[target]
+		if found {
+			return directive
+		}
+	}
+	return ""
+}
@@ -0,0 +1,138 @@
+package git
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v6/memfs"
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"github.com/go-git/go-git/v6/storage/memory"
+)
+
+func TestDirectivesParse(t *testing.T) {
+	commit := &object.Commit{
+		Hash: plumbing.NewHash("0123456789abcdef0123456789abcdef01234567"),
+		Message: "fix things [Skip SCID]\n\n" +
+			"Scid-Skip: api, web\n" +
+			"scid-force: worker\tjob\n" +
+			"Scid-Skip: api\n" +
+			"Other-Trailer: x\n",
+	}
+
+	d := directivesParse(commit)
+	if d.skipAll != "[skip scid] (0123456)" {
+		t.Errorf("skipAll = %q", d.skipAll)
+	}
+	for _, target := range []string{"api", "web"} {
+		if d.skip[target] != "Scid-Skip: "+target+" (0123456)" {
+			t.Errorf("skip[%s] = %q", target, d.skip[target])
+		}
+	}
+	for _, target := range []string{"worker", "job"} {
+		if d.force[target] != "Scid-Force: "+target+" (0123456)" {
+			t.Errorf("force[%s] = %q", target, d.force[target])
+		}
+	}
+	if len(d.skip) != 2 || len(d.force) != 2 {
+		t.Errorf("skip = %v, force = %v", d.skip, d.force)
+	}
+}
+
+func TestSkipped(t *testing.T) {
+	skipAPI := commitDirectives{skip: map[string]string{"api": "Scid-Skip: api (1)"}}
+	skipAll := commitDirectives{skipAll: "[skip scid] (2)"}
+	forceAPI := commitDirectives{force: map[string]string{"api": "Scid-Force: api (3)"}}
+	none := commitDirectives{}
+
+	tests := []struct {
+		name       string
+		directives directives
+		target     string
+		want       string
+	}{
+		{"no commits", nil, "api", ""},
+		{"skipped", directives{skipAPI}, "api", "Scid-Skip: api (1)"},
+		{"other target", directives{skipAPI}, "web", ""},
+		{"skip all", directives{skipAll}, "web", "[skip scid] (2)"},
+		{"every commit", directives{skipAll, skipAPI}, "api", "[skip scid] (2)"},
+		{"not every commit", directives{skipAPI, none}, "api", ""},
+		{"skip all not every commit", directives{none, skipAll}, "api", ""},
+		{"forced", directives{skipAll, forceAPI}, "api", ""},
+	}
+
+	for _, test := range tests {
+		g := Git{directives: test.directives}
+		got := g.Skipped(test.target)
+		if got != test.want {
+			t.Errorf("%s: Skipped(%q) = %q, want %q", test.name, test.target, got, test.want)
+		}
+	}
+}
+
+func testCommit(t *testing.T, repo *git.Repository, message string, when time.Time, parents ...plumbing.Hash) plumbing.Hash {
+	t.Helper()
+	workTree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature := &object.Signature{Name: "scid", Email: "scid@example.com", When: when}
+	hash, err := workTree.Commit(message, &git.CommitOptions{
+		AllowEmptyCommits: true,
+		Author:            signature,
+		Committer:         signature,
+		Parents:           parents,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestCommitRange(t *testing.T) {
+	repo, err := git.Init(memory.NewStorage(), git.WithWorkTree(memfs.New()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// base - old ------- merge
+	//   \               /
+	//    feature1 - feature2
+	now := time.Now()
+	base := testCommit(t, repo, "base", now)
+	old := testCommit(t, repo, "old", now.Add(time.Minute), base)
+	feature1 := testCommit(t, repo, "feature1", now.Add(2*time.Minute), base)
+	feature2 := testCommit(t, repo, "feature2", now.Add(3*time.Minute), feature1)
+	merge := testCommit(t, repo, "merge", now.Add(4*time.Minute), old, feature2)
+
+	tests := []struct {
+		name    string
+		oldHash *plumbing.Hash
+		newHash plumbing.Hash
+		want    []plumbing.Hash
+	}{
+		{"first run", nil, merge, []plumbing.Hash{merge}},
+		{"merged branch", &old, merge, []plumbing.Hash{merge, feature2, feature1}},
+		{"fast-forward", &feature1, feature2, []plumbing.Hash{feature2}},
+		{"rewritten", &old, feature2, []plumbing.Hash{feature2, feature1}},
+		{"rollback", &merge, old, nil},
+	}
+
+	for _, test := range tests {
+		commits, err := commitRange(repo, test.oldHash, test.newHash)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		var got []plumbing.Hash
+		for _, commit := range commits {
+			got = append(got, commit.Hash)
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%s: commitRange = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -1,126 +1,87 @@
 package git
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
-	"slices"
+	"os/exec"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/go-git/go-git/v6"
+	gitconfig "github.com/go-git/go-git/v6/config"
 	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
 	"github.com/go-git/go-git/v6/plumbing/transport"
-	"github.com/go-git/go-git/v6/plumbing/transport/ssh"
-	"golang.org/x/mod/semver"
-	"lukechampine.com/blake3"
+	"github.com/go-git/go-git/v6/plumbing/transport/http"
 	"sinanmohd.com/scid/internal/config"
 )
 
 type Git struct {
-	LocalPath        string
+	// drivers run here, a temporary checkout during rollbacks
+	LocalPath string
+	// the clone, its metadata lives next to it
+	statePath        string
 	repo             *git.Repository
 	NewHash, OldHash *plumbing.Hash
 	changedPaths     []string
+	directives       directives
+
+	// OldHash is not an ancestor of NewHash anymore
+	HistoryRewritten bool
+	// NewHash is an older commit redeployed by Rollback
+	Rollback bool
+	// branch HEAD during the rollback
+	pinHash plumbing.Hash
 }
 
-// NOTE: not thread safe
-var knownHostsFile string
-
-func authFromSSHConfig(sshConfig *config.SSHConfig) (transport.AuthMethod, error) {
-	auth, err := ssh.NewPublicKeys("git", []byte(sshConfig.Key), "")
-	if err != nil {
-		return nil, err
+func authFromHTTPSConfig(httpsConfig *config.HTTPSConfig) (transport.AuthMethod, error) {
+	secret := httpsConfig.Password
+	if httpsConfig.Username == "" {
+		secret = httpsConfig.Token
 	}
-	if sshConfig.KnownHosts == "" {
-		return auth, nil
-	}
-
-	if knownHostsFile == "" {
-		tmpFile, err := os.CreateTemp("", "scid-ssh-know-hosts-*")
+	if len(httpsConfig.CredentialHelper) > 0 {
+		output, err := exec.Command(httpsConfig.CredentialHelper[0], httpsConfig.CredentialHelper[1:]...).Output()
 		if err != nil {
-			return nil, err
-		}
-		_, err = tmpFile.WriteString(sshConfig.KnownHosts)
-		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("running credential helper: %w", err)
 		}
-		knownHostsFile = tmpFile.Name()
+		secret = strings.TrimSpace(string(output))
 	}
-	hostKeyCallback, err := ssh.NewKnownHostsCallback(knownHostsFile)
-	if err != nil {
-		return nil, err
-	}
-	auth.HostKeyCallback = hostKeyCallback
-
-	return auth, nil
-}
-
-func hashFromTag(tag *config.Tag, repo *git.Repository) (*plumbing.Hash, error) {
-	switch tag.Model {
-	case config.TagModelStatic:
-		hash, err := repo.ResolveRevision(plumbing.Revision(tag.Value))
-		return hash, err
-	case config.TagModelSemver:
-		tagRefs, err := repo.Tags()
-		if err != nil {
-			return nil, err
-		}
-
-		var versions []string
-		tagRefs.ForEach(func(tafRef *plumbing.Reference) error {
-			// golnag semver is not spec compliant
-			version := "v" + tafRef.Name().Short()
-			if semver.IsValid(version) {
-				versions = append(versions, version)
-			}
-			return nil
-		})
-		if len(versions) <= 0 {
-			return nil, errors.New("No semver tag")
-		}
-
-		// golnag semver is not spec compliant
-		latestVersion := slices.MaxFunc(versions, semver.Compare)[1:]
-		hash, err := repo.ResolveRevision(plumbing.Revision(latestVersion))
-		return hash, err
-	default:
-		return nil, fmt.Errorf("unsupported tag model: %s", tag.Model)
 
+	if httpsConfig.Username != "" {
+		return &http.BasicAuth{
+			Username: httpsConfig.Username,
+			Password: secret,
+		}, nil
 	}
+	return &http.TokenAuth{Token: secret}, nil
 }
 
-func checkoutTag(tag *config.Tag, repo *git.Repository) error {
-	hash, err := hashFromTag(tag, repo)
-	if err != nil {
-		return err
-	}
-
-	workTree, err := repo.Worktree()
-	if err != nil {
-		return err
+func authMethod(sshConfig *config.SSHConfig, httpsConfig *config.HTTPSConfig) (transport.AuthMethod, error) {
+	if sshConfig != nil {
+		return authFromSSHConfig(sshConfig)
+	} else if httpsConfig != nil {
+		return authFromHTTPSConfig(httpsConfig)
 	}
 
-	workTree.Checkout(&git.CheckoutOptions{
-		Hash: *hash,
-	})
-
-	return nil
+	return nil, nil
 }
 
-func cloneRepo(localPath, repoUrl, branchName string, sshConfig *config.SSHConfig, tag *config.Tag) (*Git, error) {
+func cloneRepo(localPath, repoUrl, branchName string, auth transport.AuthMethod, tag *config.Tag) (*Git, error) {
 
 	cloneOpts := &git.CloneOptions{
 		URL:           repoUrl,
 		SingleBranch:  true,
 		ReferenceName: plumbing.NewBranchReferenceName(branchName),
 		Progress:      os.Stdout,
-	}
-	if sshConfig != nil {
-		auth, err := authFromSSHConfig(sshConfig)
-		if err != nil {
-			return nil, err
-		}
-		cloneOpts.Auth = auth
+		Auth:          auth,
+		Depth:         config.Config.Clone.Depth,
+		Filter:        cloneFilter(),
+		// checked out below, limited to the sparse dirs
+		NoCheckout: config.Config.Clone.Sparse,
 	}
 
 	repo, err := git.PlainClone(localPath, cloneOpts)
@@ -128,12 +89,21 @@ func cloneRepo(localPath, repoUrl, branchName string, sshConfig *config.SSHConfi
 		return nil, err
 	}
 
+	var selectedTag string
 	if tag.Model != config.TagModelDisabled {
-		err = checkoutTag(tag, repo)
-		if err != nil {
-			return nil, err
+		selectedTag, err = checkoutTag(tag, branchName, repo, auth)
+	} else if config.Config.Clone.Sparse {
+		var workTree *git.Worktree
+		workTree, err = repo.Worktree()
+		if err == nil {
+			err = checkoutSparse(repo, workTree, &git.CheckoutOptions{
+				Branch: plumbing.NewBranchReferenceName(branchName),
+			})
 		}
 	}
+	if err != nil {
+		return nil, err
+	}
 
 	headRef, err := repo.Head()
 	if err != nil {
@@ -141,43 +111,94 @@ func cloneRepo(localPath, repoUrl, branchName string, sshConfig *config.SSHConfi
 	}
 	newHash := headRef.Hash()
 
-	return &Git{
+	if config.Config.Verify != nil {
+		err = verifyHead(config.Config.Verify, selectedTag, repo, newHash)
+		if err != nil {
+			removeErr := os.RemoveAll(localPath)
+			if removeErr != nil {
+				return nil, removeErr
+			}
+			return nil, &UnverifiedError{Hash: newHash, Err: err}
+		}
+	}
+
+	// only after verification, the gitlinks are part of the signed tree
+	workTree, err := repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	err = updateSubmodules(workTree, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	g := Git{
 		LocalPath: localPath,
+		statePath: localPath,
 		repo:      repo,
 		NewHash:   &newHash,
 		OldHash:   nil,
-	}, nil
+	}
+	err = g.directivesSet()
+	if err != nil {
+		return nil, err
+	}
+
+	return &g, nil
 }
 
-func pullBranch(workTree *git.Worktree, branchName string, sshConfig *config.SSHConfig) error {
-	err := workTree.Checkout(&git.CheckoutOptions{
+// returns true if the branch history was rewritten and the local
+// branch was reset onto the remote one
+func pullBranch(repo *git.Repository, workTree *git.Worktree, branchName string, auth transport.AuthMethod) (bool, error) {
+	err := checkoutSparse(repo, workTree, &git.CheckoutOptions{
 		Branch: plumbing.NewBranchReferenceName(branchName),
 	})
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	pullOpts := &git.PullOptions{
-		SingleBranch: true,
-		Auth:         nil,
+	err = repo.Fetch(&git.FetchOptions{
+		RefSpecs: []gitconfig.RefSpec{branchRefSpec(branchName)},
+		Depth:    config.Config.Clone.Depth,
+		Filter:   cloneFilter(),
+		Auth:     auth,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return false, err
 	}
-	if sshConfig != nil {
-		auth, err := authFromSSHConfig(sshConfig)
-		if err != nil {
-			return err
-		}
-		pullOpts.Auth = auth
+
+	headRef, err := repo.Head()
+	if err != nil {
+		return false, err
+	}
+	remoteRef, err := repo.Reference(remoteBranchRefName(branchName), true)
+	if err != nil {
+		return false, err
+	}
+	if headRef.Hash() == remoteRef.Hash() {
+		return false, nil
 	}
 
-	err = workTree.Pull(pullOpts)
-	if err != nil && err != git.NoErrAlreadyUpToDate {
-		return err
+	fastForward, err := isAncestor(repo, headRef.Hash(), remoteRef.Hash())
+	if err != nil {
+		return false, err
+	}
+	if !fastForward && config.Config.OnHistoryRewrite != config.HistoryRewriteReset {
+		return false, git.ErrNonFastForwardUpdate
+	}
+
+	err = resetTo(repo, workTree, remoteRef.Hash())
+	if err != nil {
+		return false, err
+	}
+	if !fastForward {
+		slog.Warn("branch history rewritten, reset to remote", "branch", branchName, "hash", remoteRef.Hash())
 	}
 
-	return nil
+	return !fastForward, nil
 }
 
-func updateRepo(localPath, branchName string, tag *config.Tag, sshConfig *config.SSHConfig) (*Git, error) {
+func updateRepo(localPath, branchName string, tag *config.Tag, auth transport.AuthMethod) (*Git, error) {
 	// get oldHash
 	repo, err := git.PlainOpen(localPath)
 	if err != nil {
@@ -187,19 +208,37 @@ func updateRepo(localPath, branchName string, tag *config.Tag, sshConfig *config
 	if err != nil {
 		return nil, err
 	}
-	oldHash := headRef.Hash()
+	headHash := headRef.Hash()
+	oldHash, err := deployedHash(localPath, repo, headHash)
+	if err != nil {
+		return nil, err
+	}
 
 	// get newHash
-	workTree, err := repo.Worktree()
+	unchanged, err := remoteUnchanged(repo, branchName, tag, auth)
 	if err != nil {
 		return nil, err
 	}
-	err = pullBranch(workTree, branchName, sshConfig)
+	var selectedTag string
+	var historyRewritten bool
+	workTree, err := repo.Worktree()
 	if err != nil {
 		return nil, err
 	}
-	if tag.Model != config.TagModelDisabled {
-		err = checkoutTag(tag, repo)
+	if unchanged {
+		fetchesSkipped.Inc()
+		slog.Debug("remote refs unchanged, skipping fetch", "hash", headHash)
+	} else {
+		fetches.Inc()
+		err = cleanWorktree(repo, workTree)
+		if err != nil {
+			return nil, err
+		}
+		if tag.Model == config.TagModelDisabled {
+			historyRewritten, err = pullBranch(repo, workTree, branchName, auth)
+		} else {
+			selectedTag, err = checkoutTag(tag, branchName, repo, auth)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -210,14 +249,41 @@ func updateRepo(localPath, branchName string, tag *config.Tag, sshConfig *config
 	}
 	newHash := headRef.Hash()
 
+	if config.Config.Verify != nil && newHash != headHash {
+		err = verifyHead(config.Config.Verify, selectedTag, repo, newHash)
+		if err != nil {
+			// go back, so the unverified commit is never seen as deployed
+			resetErr := resetTo(repo, workTree, headHash)
+			if resetErr != nil {
+				return nil, resetErr
+			}
+			return nil, &UnverifiedError{OldHash: oldHash, Hash: newHash, Err: err}
+		}
+	}
+	if !unchanged {
+		err = updateSubmodules(workTree, auth)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// get changed paths
 	g := Git{
 		LocalPath: localPath,
+		statePath: localPath,
 		repo:      repo,
 		NewHash:   &newHash,
-		OldHash:   &oldHash,
+		OldHash:   oldHash,
+
+		HistoryRewritten: historyRewritten,
 	}
-	err = g.changedPathsSet()
+	if oldHash != nil {
+		err = g.changedPathsSet()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = g.directivesSet()
 	if err != nil {
 		return nil, err
 	}
@@ -225,18 +291,52 @@ func updateRepo(localPath, branchName string, tag *config.Tag, sshConfig *config
 	return &g, nil
 }
 
-func New(repoUrl, branchName string, tag *config.Tag, ssh *config.SSHConfig) (*Git, error) {
-	sum256 := blake3.Sum256([]byte(repoUrl + branchName))
-	localPath := fmt.Sprintf("%x", sum256)
+func New(repoUrl, branchName string, tag *config.Tag, ssh *config.SSHConfig, https *config.HTTPSConfig) (*Git, error) {
+	localPath, err := clonePath(repoUrl, branchName)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Dir(localPath), 0o755)
+	if err != nil {
+		return nil, err
+	}
+	err = cloneMetaWrite(localPath, repoUrl, branchName)
+	if err != nil {
+		return nil, err
+	}
 
-	_, err := os.Stat(localPath)
+	// resolved on every call, so credential helpers can mint fresh tokens
+	auth, err := authMethod(ssh, https)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = os.Stat(localPath)
 	if os.IsNotExist(err) {
-		return cloneRepo(localPath, repoUrl, branchName, ssh, tag)
+		// a previous re-clone might have failed half way
+		_, err = os.Stat(localPath + brokenSuffix)
+		if err == nil {
+			return recloneRepo(localPath, repoUrl, branchName, auth, tag)
+		}
+		return cloneRepo(localPath, repoUrl, branchName, auth, tag)
 	} else if err != nil {
 		return nil, err
 	}
 
-	return updateRepo(localPath, branchName, tag, ssh)
+	g, err := updateRepo(localPath, branchName, tag, auth)
+	var unverified *UnverifiedError
+	var tagMoved *TagMovedError
+	if err == nil || errors.As(err, &unverified) || errors.As(err, &tagMoved) {
+		return g, err
+	}
+
+	checkErr := checkRepo(localPath)
+	if checkErr == nil {
+		// most likely the network, keep the clone
+		return nil, err
+	}
+	slog.Error("local clone is broken, re-cloning", "err", err, "checkErr", checkErr)
+	return recloneRepo(localPath, repoUrl, branchName, auth, tag)
 }
 
 // go-git has concurrency issues: https://github.com/go-git/go-git/issues/773
@@ -262,7 +362,8 @@ func (g *Git) changedPathsSet() error {
 		return err
 	}
 
-	changes, err := treeOld.Diff(treeNew)
+	// no rename detection, it reads blobs that partial clones might not have
+	changes, err := object.DiffTreeWithOptions(context.Background(), treeOld, treeNew, nil)
 	if err != nil {
 		return err
 	}
@@ -274,6 +375,14 @@ func (g *Git) changedPathsSet() error {
 		if change.To.Name != "" {
 			g.changedPaths = append(g.changedPaths, change.To.Name)
 		}
+
+		if submoduleDepth() != git.NoRecurseSubmodules && isSubmoduleChange(change) {
+			submodulePaths, err := submoduleChangedPaths(g.repo, "", change, submoduleDepth())
+			if err != nil {
+				return err
+			}
+			g.changedPaths = append(g.changedPaths, submodulePaths...)
+		}
 	}
 
 	return err
@@ -287,6 +396,16 @@ func (g *Git) HeadMoved() bool {
 	return *g.NewHash != *g.OldHash
 }
 
+// committer date of NewHash
+func (g *Git) CommitTime() (time.Time, error) {
+	commit, err := g.repo.CommitObject(*g.NewHash)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return commit.Committer.When, nil
+}
+
 func (g *Git) ArePathsChanged(prefixPaths []string) string {
 	if config.Config.ForceReRun {
 		return "/force-re-run"
@@ -0,0 +1,181 @@
+package git
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"github.com/go-git/go-git/v6/plumbing/transport"
+	"sinanmohd.com/scid/internal/config"
+)
+
+const brokenSuffix = ".broken"
+
+// jobs run inside the worktree and can leave files behind
+func cleanWorktree(repo *git.Repository, workTree *git.Worktree) error {
+	status, err := workTree.Status()
+	if err != nil {
+		return err
+	}
+	if status.IsClean() {
+		return nil
+	}
+
+	slog.Warn("worktree is dirty, resetting", "status", status.String())
+	headRef, err := repo.Head()
+	if err != nil {
+		return err
+	}
+	err = resetTo(repo, workTree, headRef.Hash())
+	if err != nil {
+		return err
+	}
+
+	return workTree.Clean(&git.CleanOptions{
+		Dir: true,
+	})
+}
+
+// reads HEAD's trees, the blobs scid checks out and the worktree status,
+// enough to catch the usual "zlib: invalid header" kind of corruption
+func checkRepo(localPath string) error {
+	repo, err := git.PlainOpen(localPath)
+	if err != nil {
+		return err
+	}
+	headRef, err := repo.Head()
+	if err != nil {
+		return err
+	}
+	commit, err := repo.CommitObject(headRef.Hash())
+	if err != nil {
+		return err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+	dirs, err := sparseDirsIn(repo, commit.Hash, localPath)
+	if err != nil {
+		return err
+	}
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		name, entry, err := walker.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if !entry.Mode.IsFile() || !inSparseDirs(name, dirs) {
+			continue
+		}
+
+		blob, err := repo.BlobObject(entry.Hash)
+		if err != nil {
+			return err
+		}
+		reader, err := blob.Reader()
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(io.Discard, reader)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	workTree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	_, err = workTree.Status()
+	return err
+}
+
+// HEAD of a broken clone is usually still readable
+func brokenHead(brokenPath string) *plumbing.Hash {
+	repo, err := git.PlainOpen(brokenPath)
+	if err != nil {
+		return nil
+	}
+	headRef, err := repo.Head()
+	if err != nil {
+		return nil
+	}
+
+	hash := headRef.Hash()
+	return &hash
+}
+
+// the deployed commit from the clone metadata, HEAD of the broken clone
+// for metadata written before deploys were tracked
+func brokenDeployed(localPath, brokenPath string) (*plumbing.Hash, error) {
+	deployed, tracked, err := cloneMetaDeployed(localPath)
+	if err != nil {
+		return nil, err
+	}
+	if !tracked {
+		return brokenHead(brokenPath), nil
+	}
+
+	return deployed, nil
+}
+
+// moves the broken clone aside and clones again, keeping the last deployed
+// commit as OldHash if the fresh clone still has it
+func recloneRepo(localPath, repoUrl, branchName string, auth transport.AuthMethod, tag *config.Tag) (*Git, error) {
+	brokenPath := localPath + brokenSuffix
+	_, err := os.Stat(localPath)
+	if err == nil {
+		err = os.RemoveAll(brokenPath)
+		if err != nil {
+			return nil, err
+		}
+		err = os.Rename(localPath, brokenPath)
+		if err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	oldHash, err := brokenDeployed(localPath, brokenPath)
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := cloneRepo(localPath, repoUrl, branchName, auth, tag)
+	if err != nil {
+		return nil, err
+	}
+	err = os.RemoveAll(brokenPath)
+	if err != nil {
+		return nil, err
+	}
+	if oldHash == nil {
+		return g, nil
+	}
+
+	_, err = g.repo.CommitObject(*oldHash)
+	if err != nil {
+		slog.Warn("last deployed commit missing from the new clone, treating everything as changed", "oldHash", oldHash, "err", err)
+		return g, nil
+	}
+	g.OldHash = oldHash
+	err = g.changedPathsSet()
+	if err != nil {
+		return nil, err
+	}
+	err = g.directivesSet()
+	if err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
@@ -0,0 +1,81 @@
+package git
+
+import (
+	"errors"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/transport"
+	"sinanmohd.com/scid/internal/config"
+	"sinanmohd.com/scid/internal/metrics"
+)
+
+var (
+	remoteChecks   = metrics.NewCounter("scid_remote_checks_total", "Remote ref listings done before fetching.")
+	fetches        = metrics.NewCounter("scid_fetches_total", "Fetches done because a remote ref changed.")
+	fetchesSkipped = metrics.NewCounter("scid_fetches_skipped_total", "Fetch and checkout round-trips saved by the remote ref listing.")
+)
+
+// lists the remote refs (like git ls-remote) without fetching any objects,
+// true if the refs scid follows still point to what was last checked out
+func remoteUnchanged(repo *git.Repository, branchName string, tag *config.Tag, auth transport.AuthMethod) (bool, error) {
+	remote, err := repo.Remote(git.DefaultRemoteName)
+	if err != nil {
+		return false, err
+	}
+	remoteRefs, err := remote.List(&git.ListOptions{Auth: auth})
+	if err != nil {
+		return false, err
+	}
+	remoteChecks.Inc()
+
+	// the ref that selects HEAD, must exist on the remote
+	followedRef := plumbing.NewBranchReferenceName(branchName)
+	if tag.Model == config.TagModelStatic {
+		followedRef = plumbing.NewTagReferenceName(tag.Value)
+	}
+
+	followedSeen := false
+	for _, remoteRef := range remoteRefs {
+		var localHash plumbing.Hash
+		switch {
+		case remoteRef.Name() == plumbing.NewBranchReferenceName(branchName) && tag.Model == config.TagModelDisabled:
+			headRef, err := repo.Head()
+			if err != nil {
+				return false, err
+			}
+			localHash = headRef.Hash()
+		case remoteRef.Name() == plumbing.NewBranchReferenceName(branchName) && tag.Model != config.TagModelStatic:
+			// tags are only considered if reachable from the branch
+			localRef, err := repo.Reference(remoteBranchRefName(branchName), true)
+			if errors.Is(err, plumbing.ErrReferenceNotFound) {
+				return false, nil
+			} else if err != nil {
+				return false, err
+			}
+			localHash = localRef.Hash()
+		case remoteRef.Name().IsTag() && tag.Model != config.TagModelDisabled:
+			if tag.Model == config.TagModelStatic && remoteRef.Name() != followedRef {
+				continue
+			}
+			localRef, err := repo.Reference(remoteRef.Name(), false)
+			if errors.Is(err, plumbing.ErrReferenceNotFound) {
+				return false, nil
+			} else if err != nil {
+				return false, err
+			}
+			localHash = localRef.Hash()
+		default:
+			continue
+		}
+
+		if remoteRef.Hash() != localHash {
+			return false, nil
+		}
+		if remoteRef.Name() == followedRef {
+			followedSeen = true
+		}
+	}
+
+	return followedSeen, nil
+}
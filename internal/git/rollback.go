@@ -0,0 +1,185 @@
+package git
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/filemode"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"sinanmohd.com/scid/internal/config"
+)
+
+const rollbackRunPrefix = "run:"
+
+// run:<id> from the run history, or anything git rev-parse understands.
+// run ids need the prefix, short hashes can be all digits too
+func rollbackHash(repo *git.Repository, meta cloneMeta, ref string) (plumbing.Hash, error) {
+	idPart, isRun := strings.CutPrefix(ref, rollbackRunPrefix)
+	if isRun {
+		id, err := strconv.Atoi(idPart)
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("invalid run id %q", idPart)
+		}
+		for _, run := range meta.Runs {
+			if run.ID == id {
+				return plumbing.NewHash(run.Hash), nil
+			}
+		}
+		return plumbing.ZeroHash, fmt.Errorf("run %d is not in the run history", id)
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("resolving %s: %w", ref, err)
+	}
+	return *hash, nil
+}
+
+func treeFileWrite(file *object.File, dir string) error {
+	path := filepath.Join(dir, filepath.FromSlash(file.Name))
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	reader, err := file.Reader()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if file.Mode == filemode.Symlink {
+		target, err := io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		// written by an earlier round of sparseCheckout
+		err = os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return os.Symlink(string(target), path)
+	}
+
+	perm := os.FileMode(0o644)
+	if file.Mode == filemode.Executable {
+		perm = 0o755
+	}
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, reader)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// writes the tree of hash to dir, leaving the clone untouched
+func treeWrite(repo *git.Repository, hash plumbing.Hash, dir string) error {
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+
+	return sparseCheckout(repo, hash, dir, func(dirs []string) error {
+		return tree.Files().ForEach(func(file *object.File) error {
+			if !inSparseDirs(file.Name, dirs) {
+				return nil
+			}
+			return treeFileWrite(file, dir)
+		})
+	})
+}
+
+// checks out ref, a commit or run:<id>, into a temporary directory as if it
+// was HEAD. OldHash is the deployed commit, Cleanup removes the checkout
+func Rollback(repoUrl, branchName, ref string) (*Git, error) {
+	localPath, err := clonePath(repoUrl, branchName)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := git.PlainOpen(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening clone %s: %w", localPath, err)
+	}
+	meta, err := cloneMetaRead(localPath)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := rollbackHash(repo, meta, ref)
+	if err != nil {
+		return nil, err
+	}
+	headRef, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+	oldHash, err := deployedHash(localPath, repo, headRef.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Config.Verify != nil {
+		// ref is checked as a tag first, signed tags of unsigned commits pass
+		err = verifyHead(config.Config.Verify, ref, repo, hash)
+		if err != nil {
+			return nil, &UnverifiedError{OldHash: oldHash, Hash: hash, Err: err}
+		}
+	}
+
+	if config.Config.Clone.Submodules != config.SubmodulesDisabled {
+		slog.Warn("rollbacks do not check out submodules")
+	}
+	rollbackPath, err := os.MkdirTemp("", "scid-rollback-*")
+	if err != nil {
+		return nil, err
+	}
+	err = treeWrite(repo, hash, rollbackPath)
+	if err != nil {
+		os.RemoveAll(rollbackPath)
+		return nil, err
+	}
+
+	g := Git{
+		LocalPath: rollbackPath,
+		statePath: localPath,
+		repo:      repo,
+		NewHash:   &hash,
+		OldHash:   oldHash,
+
+		Rollback: true,
+		pinHash:  headRef.Hash(),
+	}
+	if oldHash != nil {
+		err = g.changedPathsSet()
+		if err != nil {
+			g.Cleanup()
+			return nil, err
+		}
+	}
+
+	return &g, nil
+}
+
+// removes the temporary checkout of a rollback
+func (g *Git) Cleanup() error {
+	if !g.Rollback {
+		return nil
+	}
+
+	return os.RemoveAll(g.LocalPath)
+}
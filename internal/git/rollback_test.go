@@ -0,0 +1,62 @@
+package git
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v6/memfs"
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/storage/memory"
+)
+
+func TestRollbackHash(t *testing.T) {
+	repo, err := git.Init(memory.NewStorage(), git.WithWorkTree(memfs.New()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a commit whose short hash is all digits, like a run id
+	now := time.Now()
+	var digits plumbing.Hash
+	var short string
+	for i := 0; short == ""; i++ {
+		hash := testCommit(t, repo, fmt.Sprintf("commit %d", i), now)
+		if strings.Trim(hash.String()[:7], "0123456789") == "" {
+			digits, short = hash, hash.String()[:7]
+		}
+	}
+	first := testCommit(t, repo, "first", now)
+	meta := cloneMeta{Runs: []cloneRun{{ID: 1, Hash: first.String()}}}
+
+	tests := []struct {
+		name    string
+		ref     string
+		want    plumbing.Hash
+		wantErr bool
+	}{
+		{"full hash", first.String(), first, false},
+		{"run", "run:1", first, false},
+		{"all digit short hash", short, digits, false},
+		{"unknown run", "run:2", plumbing.ZeroHash, true},
+		{"invalid run", "run:one", plumbing.ZeroHash, true},
+		{"unknown commit", "nope", plumbing.ZeroHash, true},
+	}
+
+	for _, test := range tests {
+		got, err := rollbackHash(repo, meta, test.ref)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: rollbackHash(%q) = %s", test.name, test.ref, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if got != test.want {
+			t.Errorf("%s: rollbackHash(%q) = %s, want %s", test.name, test.ref, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package git
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"golang.org/x/mod/semver"
+	"sinanmohd.com/scid/internal/config"
+)
+
+type semverComparator struct {
+	op      string
+	version string
+}
+
+// golang semver wants a v prefix and is not spec compliant without it
+func semverCanonical(version string) (string, bool) {
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+	if !semver.IsValid(version) {
+		return "", false
+	}
+
+	return version, true
+}
+
+// upper bound for ~ and ^, partial versions bump the last given part
+func semverBump(version string, caret bool) string {
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	canonical := semver.Canonical(version)
+	major, minor := semver.Major(canonical), semver.MajorMinor(canonical)
+
+	var bumpIndex int
+	if caret {
+		// first non zero part
+		switch {
+		case major != "v0" || len(parts) == 1:
+			bumpIndex = 0
+		case minor != "v0.0" || len(parts) == 2:
+			bumpIndex = 1
+		default:
+			bumpIndex = 2
+		}
+	} else if len(parts) == 1 {
+		bumpIndex = 0
+	} else {
+		bumpIndex = 1
+	}
+
+	var nums [3]int
+	fmt.Sscanf(strings.TrimPrefix(canonical, "v"), "%d.%d.%d", &nums[0], &nums[1], &nums[2])
+	nums[bumpIndex]++
+	for i := bumpIndex + 1; i < len(nums); i++ {
+		nums[i] = 0
+	}
+
+	// -0 sorts before any other pre-release of the same version
+	return fmt.Sprintf("v%d.%d.%d-0", nums[0], nums[1], nums[2])
+}
+
+// like ^ and ~, <2 and <=1.4 exclude pre-releases of 2.0.0 and 1.5.0
+func semverUpperBound(op, version string) semverComparator {
+	withoutBuild, _, _ := strings.Cut(version, "+")
+	partial := withoutBuild != semver.Canonical(version)
+	if op == "<=" && partial {
+		return semverComparator{"<", semverBump(version, false)}
+	} else if op == "<" && semver.Prerelease(version) == "" {
+		return semverComparator{"<", semver.Canonical(version) + "-0"}
+	}
+
+	return semverComparator{op, version}
+}
+
+// space separated comparators are ANDed, || separated groups are ORed.
+// supports =, >, >=, <, <=, ~ and ^ with optionally partial versions
+func parseSemverConstraint(constraint string) ([][]semverComparator, error) {
+	var groups [][]semverComparator
+	for group := range strings.SplitSeq(constraint, "||") {
+		var comparators []semverComparator
+		for field := range strings.FieldsSeq(group) {
+			version := strings.TrimLeft(field, "=<>~^")
+			op := field[:len(field)-len(version)]
+			canonical, ok := semverCanonical(version)
+			if !ok {
+				return nil, fmt.Errorf("invalid version in semver constraint: %s", field)
+			}
+
+			switch op {
+			case "", "=":
+				withoutBuild, _, _ := strings.Cut(canonical, "+")
+				if withoutBuild != semver.Canonical(canonical) {
+					// partial version, 1.4 means ~1.4
+					comparators = append(comparators,
+						semverComparator{">=", canonical},
+						semverComparator{"<", semverBump(canonical, false)},
+					)
+				} else {
+					comparators = append(comparators, semverComparator{"=", canonical})
+				}
+			case ">", ">=":
+				comparators = append(comparators, semverComparator{op, canonical})
+			case "<", "<=":
+				comparators = append(comparators, semverUpperBound(op, canonical))
+			case "~", "^":
+				comparators = append(comparators,
+					semverComparator{">=", canonical},
+					semverComparator{"<", semverBump(canonical, op == "^")},
+				)
+			default:
+				return nil, fmt.Errorf("invalid operator in semver constraint: %s", field)
+			}
+		}
+		if len(comparators) == 0 {
+			return nil, fmt.Errorf("empty semver constraint group: %q", constraint)
+		}
+
+		groups = append(groups, comparators)
+	}
+
+	return groups, nil
+}
+
+func (c semverComparator) match(version string) bool {
+	cmp := semver.Compare(version, c.version)
+	switch c.op {
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	default:
+		return cmp == 0
+	}
+}
+
+func semverMatch(groups [][]semverComparator, version string) bool {
+	if len(groups) == 0 {
+		return true
+	}
+
+	for _, group := range groups {
+		matched := true
+		for _, comparator := range group {
+			if !comparator.match(version) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// returns the tag name of the latest version matching the tag config
+func latestSemverTag(tag *config.Tag, tags []branchTag) (string, error) {
+	var constraint [][]semverComparator
+	if tag.Constraint != "" {
+		var err error
+		constraint, err = parseSemverConstraint(tag.Constraint)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	versionTags := make(map[string]string)
+	for _, branchTag := range tags {
+		version, found := strings.CutPrefix(branchTag.name, tag.Prefix)
+		if !found {
+			continue
+		}
+		version, ok := semverCanonical(version)
+		if !ok {
+			continue
+		}
+		// skip floating tags like v1 or v1.4
+		withoutBuild, _, _ := strings.Cut(version, "+")
+		if withoutBuild != semver.Canonical(version) {
+			continue
+		}
+		if !tag.AllowPrerelease && semver.Prerelease(version) != "" {
+			continue
+		}
+		if !semverMatch(constraint, version) {
+			continue
+		}
+
+		versionTags[version] = branchTag.name
+	}
+	if len(versionTags) <= 0 {
+		return "", fmt.Errorf("no semver tag matching prefix %q and constraint %q", tag.Prefix, tag.Constraint)
+	}
+
+	versions := make([]string, 0, len(versionTags))
+	for version := range versionTags {
+		versions = append(versions, version)
+	}
+	return versionTags[slices.MaxFunc(versions, semver.Compare)], nil
+}
@@ -0,0 +1,118 @@
+package git
+
+import (
+	"testing"
+
+	"sinanmohd.com/scid/internal/config"
+)
+
+func TestSemverBump(t *testing.T) {
+	tests := []struct {
+		version string
+		caret   bool
+		want    string
+	}{
+		{"v1", false, "v2.0.0-0"},
+		{"v1.4", false, "v1.5.0-0"},
+		{"v1.4.2", false, "v1.5.0-0"},
+		{"v1", true, "v2.0.0-0"},
+		{"v1.4.2", true, "v2.0.0-0"},
+		{"v0.4.2", true, "v0.5.0-0"},
+		{"v0.0.3", true, "v0.0.4-0"},
+		{"v0.0", true, "v0.1.0-0"},
+		{"v0", true, "v1.0.0-0"},
+	}
+
+	for _, test := range tests {
+		got := semverBump(test.version, test.caret)
+		if got != test.want {
+			t.Errorf("semverBump(%q, %v) = %q, want %q", test.version, test.caret, got, test.want)
+		}
+	}
+}
+
+func TestParseSemverConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		match      []string
+		noMatch    []string
+	}{
+		{"1.4", []string{"v1.4.0", "v1.4.9"}, []string{"v1.3.9", "v1.5.0", "v1.5.0-rc.1"}},
+		{"=1.4.2", []string{"v1.4.2"}, []string{"v1.4.3"}},
+		{"~1.4", []string{"v1.4.0", "v1.4.7"}, []string{"v1.5.0", "v1.5.0-rc.1"}},
+		{"^1", []string{"v1.0.0", "v1.9.9"}, []string{"v0.9.0", "v2.0.0-rc.1", "v2.0.0"}},
+		{"^0.4.2", []string{"v0.4.2", "v0.4.9"}, []string{"v0.5.0", "v0.4.1"}},
+		{">=1.4 <2", []string{"v1.4.0", "v1.9.9"}, []string{"v1.3.0", "v2.0.0-rc.1", "v2.0.0"}},
+		{"<2.0.0-rc.2", []string{"v2.0.0-rc.1"}, []string{"v2.0.0-rc.2"}},
+		{"<=1.4", []string{"v1.4.9"}, []string{"v1.5.0-rc.1", "v1.5.0"}},
+		{"<=1.4.2", []string{"v1.4.2", "v1.4.2-rc.1"}, []string{"v1.4.3"}},
+		{">1.4.2", []string{"v1.4.3"}, []string{"v1.4.2"}},
+		{"^1 || ^3", []string{"v1.2.0", "v3.0.0"}, []string{"v2.0.0"}},
+	}
+
+	for _, test := range tests {
+		groups, err := parseSemverConstraint(test.constraint)
+		if err != nil {
+			t.Errorf("parseSemverConstraint(%q): %s", test.constraint, err)
+			continue
+		}
+		for _, version := range test.match {
+			if !semverMatch(groups, version) {
+				t.Errorf("%q does not match %s", test.constraint, version)
+			}
+		}
+		for _, version := range test.noMatch {
+			if semverMatch(groups, version) {
+				t.Errorf("%q matches %s", test.constraint, version)
+			}
+		}
+	}
+
+	for _, constraint := range []string{"", "^1 ||", "1.x", "!1.4", ">=abc"} {
+		_, err := parseSemverConstraint(constraint)
+		if err == nil {
+			t.Errorf("parseSemverConstraint(%q) did not fail", constraint)
+		}
+	}
+}
+
+func TestLatestSemverTag(t *testing.T) {
+	tags := []branchTag{
+		{name: "v1.3.0"},
+		{name: "v1.4.0"},
+		{name: "1.4.2"},
+		{name: "v1.4"},
+		{name: "v2.0.0-rc.1"},
+		{name: "api/v1.9.0"},
+		{name: "api/v2.1.0"},
+		{name: "release"},
+	}
+	tests := []struct {
+		tag     config.Tag
+		want    string
+		wantErr bool
+	}{
+		{config.Tag{}, "1.4.2", false},
+		{config.Tag{AllowPrerelease: true}, "v2.0.0-rc.1", false},
+		{config.Tag{Constraint: ">=1.4 <2", AllowPrerelease: true}, "1.4.2", false},
+		{config.Tag{Constraint: "~1.3"}, "v1.3.0", false},
+		{config.Tag{Prefix: "api/"}, "api/v2.1.0", false},
+		{config.Tag{Prefix: "api/", Constraint: "^1"}, "api/v1.9.0", false},
+		{config.Tag{Constraint: "^3"}, "", true},
+	}
+
+	for _, test := range tests {
+		got, err := latestSemverTag(&test.tag, tags)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("latestSemverTag(%+v) = %q, want an error", test.tag, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("latestSemverTag(%+v): %s", test.tag, err)
+		} else if got != test.want {
+			t.Errorf("latestSemverTag(%+v) = %q, want %q", test.tag, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package git
+
+import (
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+	gitconfig "github.com/go-git/go-git/v6/config"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/filemode"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"github.com/go-git/go-git/v6/plumbing/protocol/packp"
+	"sinanmohd.com/scid/internal/config"
+)
+
+func branchRefSpec(branchName string) gitconfig.RefSpec {
+	return gitconfig.RefSpec("+" + plumbing.NewBranchReferenceName(branchName) + ":" + remoteBranchRefName(branchName))
+}
+
+func cloneFilter() packp.Filter {
+	return packp.Filter(config.Config.Clone.Filter)
+}
+
+// bounds rounds of discovery, each checks out more paths to discover in
+const sparseDiscoverMax = 8
+
+// repo relative paths drivers read besides the configured ones, found in
+// dir once those are checked out there. eg: helm values outside charts
+var SparseDiscover func(dir string) ([]string, error)
+
+// repo relative paths scid reads, nil if sparse checkout is disabled
+func sparsePaths() []string {
+	if !config.Config.Clone.Sparse {
+		return nil
+	}
+
+	paths := slices.Clone(config.Config.Clone.SparsePaths)
+	for _, job := range config.Config.Jobs {
+		paths = append(paths, job.WatchPaths...)
+	}
+	if config.Config.Helm != nil {
+		for _, root := range config.Config.Helm.Roots() {
+			root = filepath.ToSlash(root)
+			// globs check out the directory they start in
+			i := strings.IndexAny(root, "*?[")
+			if i >= 0 {
+				root = filepath.Clean(root[:strings.LastIndex(root[:i], "/")+1])
+			}
+			paths = append(paths, root)
+		}
+	}
+
+	return paths
+}
+
+// directories to check out for hash, watch paths pointing to files
+// check out their parent. nil means everything
+func sparseDirs(repo *git.Repository, hash plumbing.Hash, extra []string) ([]string, error) {
+	paths := sparsePaths()
+	if paths == nil {
+		return nil, nil
+	}
+	paths = append(paths, extra...)
+
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	for _, path := range paths {
+		path = filepath.ToSlash(filepath.Clean(path))
+		if path == "." || path == "/" {
+			return nil, nil
+		}
+
+		entry, err := tree.FindEntry(path)
+		if errors.Is(err, object.ErrEntryNotFound) || errors.Is(err, object.ErrDirectoryNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if entry.Mode != filemode.Dir {
+			path = filepath.ToSlash(filepath.Dir(path))
+			if path == "." {
+				return nil, nil
+			}
+		}
+		dirs = append(dirs, path)
+	}
+	slices.Sort(dirs)
+
+	return slices.Compact(dirs), nil
+}
+
+func sparseDiscover(dir string) []string {
+	if SparseDiscover == nil || sparsePaths() == nil {
+		return nil
+	}
+
+	paths, err := SparseDiscover(dir)
+	if err != nil {
+		// drivers fail on it again, with what is checked out
+		slog.Warn("discovering sparse checkout paths", "err", err)
+		return nil
+	}
+	return paths
+}
+
+// sparse dirs of hash, with what SparseDiscover finds in dir
+func sparseDirsIn(repo *git.Repository, hash plumbing.Hash, dir string) ([]string, error) {
+	return sparseDirs(repo, hash, sparseDiscover(dir))
+}
+
+// checks out the sparse dirs of hash to dir, then what SparseDiscover
+// finds in there until nothing new turns up
+func sparseCheckout(repo *git.Repository, hash plumbing.Hash, dir string, checkout func(dirs []string) error) error {
+	var extra []string
+	for range sparseDiscoverMax {
+		dirs, err := sparseDirs(repo, hash, extra)
+		if err != nil {
+			return err
+		}
+		err = checkout(dirs)
+		if err != nil || dirs == nil {
+			return err
+		}
+
+		extra = sparseDiscover(dir)
+		widerDirs, err := sparseDirs(repo, hash, extra)
+		if err != nil {
+			return err
+		} else if widerDirs != nil && !slices.ContainsFunc(widerDirs, func(dir string) bool {
+			return !inSparseDirs(dir, dirs)
+		}) {
+			return nil
+		}
+	}
+
+	slog.Warn("sparse checkout paths keep growing, giving up", "rounds", sparseDiscoverMax)
+	return nil
+}
+
+func inSparseDirs(path string, dirs []string) bool {
+	if dirs == nil {
+		return true
+	}
+
+	for _, dir := range dirs {
+		if path == dir || strings.HasPrefix(path, dir+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// go-git only ever sets skip-worktree, the entries of dirs a reset adds
+// would stay skipped otherwise. merge resets see them as deleted then
+func sparseUnskip(repo *git.Repository) error {
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return err
+	}
+	for _, entry := range idx.Entries {
+		entry.SkipWorktree = false
+	}
+	return repo.Storer.SetIndex(idx)
+}
+
+func resetSparse(repo *git.Repository, workTree *git.Worktree, hash plumbing.Hash, dirs []string) error {
+	err := sparseUnskip(repo)
+	if err != nil {
+		return err
+	}
+
+	return workTree.Reset(&git.ResetOptions{
+		Commit:     hash,
+		Mode:       git.HardReset,
+		SparseDirs: dirs,
+	})
+}
+
+// hard resets the current branch, or detached HEAD, onto hash
+func resetTo(repo *git.Repository, workTree *git.Worktree, hash plumbing.Hash) error {
+	return sparseCheckout(repo, hash, workTree.Filesystem.Root(), func(dirs []string) error {
+		return resetSparse(repo, workTree, hash, dirs)
+	})
+}
+
+func checkoutSparse(repo *git.Repository, workTree *git.Worktree, opts *git.CheckoutOptions) error {
+	hash := opts.Hash
+	if opts.Branch != "" {
+		branchRef, err := repo.Reference(opts.Branch, true)
+		if err != nil {
+			return err
+		}
+		hash = branchRef.Hash()
+	}
+
+	// discovered paths widen what the first checkout got
+	checkedOut := false
+	return sparseCheckout(repo, hash, workTree.Filesystem.Root(), func(dirs []string) error {
+		if checkedOut {
+			return resetSparse(repo, workTree, hash, dirs)
+		}
+		checkedOut = true
+		opts.SparseCheckoutDirectories = dirs
+		return workTree.Checkout(opts)
+	})
+}
+
+// shallow clones can't always tell, those are assumed to be rewritten so
+// on_history_rewrite decides
+func isAncestor(repo *git.Repository, ancestor, hash plumbing.Hash) (bool, error) {
+	ancestorCommit, err := repo.CommitObject(ancestor)
+	if err != nil {
+		return false, err
+	}
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return false, err
+	}
+
+	ok, err := ancestorCommit.IsAncestor(commit)
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		shallows, shallowErr := repo.Storer.Shallow()
+		if shallowErr == nil && len(shallows) > 0 {
+			slog.Warn("history beyond the clone depth, treating the update as a history rewrite", "ancestor", ancestor, "hash", hash, "depth", config.Config.Clone.Depth)
+			return false, nil
+		}
+	}
+
+	return ok, err
+}
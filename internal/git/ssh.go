@@ -0,0 +1,202 @@
+package git
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v6/plumbing/transport"
+	"github.com/go-git/go-git/v6/plumbing/transport/ssh"
+	cryptossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"sinanmohd.com/scid/internal/config"
+)
+
+const defaultSSHUser = "git"
+
+type knownHost struct {
+	patterns []string
+	key      cryptossh.PublicKey
+	revoked  bool
+}
+
+func parseKnownHosts(data string) ([]knownHost, error) {
+	var knownHosts []knownHost
+	rest := []byte(data)
+	for len(bytes.TrimSpace(rest)) > 0 {
+		marker, hosts, key, _, next, err := cryptossh.ParseKnownHosts(rest)
+		if err != nil {
+			return nil, err
+		}
+		rest = next
+
+		// certificate authorities are not supported
+		if marker == "cert-authority" {
+			continue
+		}
+		knownHosts = append(knownHosts, knownHost{
+			patterns: hosts,
+			key:      key,
+			revoked:  marker == "revoked",
+		})
+	}
+
+	return knownHosts, nil
+}
+
+// openssh style '*' and '?' wildcards
+func wildcardMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if wildcardMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+
+	return len(s) == 0
+}
+
+func hashedHostMatch(pattern, host string) bool {
+	// |1|base64(salt)|base64(hmac-sha1(salt, host))
+	parts := strings.Split(pattern, "|")
+	if len(parts) != 4 || parts[1] != "1" {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	hash, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return hmac.Equal(mac.Sum(nil), hash)
+}
+
+func (k *knownHost) match(host string) bool {
+	matched := false
+	for _, pattern := range k.patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+
+		var patternMatch bool
+		if strings.HasPrefix(pattern, "|") {
+			patternMatch = hashedHostMatch(pattern, host)
+		} else {
+			patternMatch = wildcardMatch(pattern, host)
+		}
+
+		if patternMatch && negated {
+			return false
+		} else if patternMatch {
+			matched = true
+		}
+	}
+
+	return matched
+}
+
+// accepts a host key if any of the configured sources trusts it, sources
+// are read once per call so every repo update sees the latest known_hosts
+func hostKeyCallback(sshConfig *config.SSHConfig) (cryptossh.HostKeyCallback, error) {
+	knownHosts, err := parseKnownHosts(sshConfig.KnownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("parsing known_hosts: %w", err)
+	}
+
+	var fileCallback cryptossh.HostKeyCallback
+	if sshConfig.KnownHostsFile != "" {
+		fileCallback, err = knownhosts.New(sshConfig.KnownHostsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return func(hostname string, remote net.Addr, key cryptossh.PublicKey) error {
+		host := knownhosts.Normalize(hostname)
+		fingerprint := cryptossh.FingerprintSHA256(key)
+
+		// like openssh, a revoked key is refused for every host and
+		// whatever trusts it
+		for _, knownHost := range knownHosts {
+			if knownHost.revoked && bytes.Equal(knownHost.key.Marshal(), key.Marshal()) {
+				return fmt.Errorf("host key %s for %s is revoked", fingerprint, host)
+			}
+		}
+
+		for _, knownHost := range knownHosts {
+			if !knownHost.revoked && knownHost.match(host) && bytes.Equal(knownHost.key.Marshal(), key.Marshal()) {
+				return nil
+			}
+		}
+
+		if slices.Contains(sshConfig.HostKeyFingerprints, fingerprint) {
+			return nil
+		}
+
+		if fileCallback != nil {
+			return fileCallback(hostname, remote, key)
+		}
+
+		return fmt.Errorf("untrusted host key %s for %s", fingerprint, host)
+	}, nil
+}
+
+func authFromSSHConfig(sshConfig *config.SSHConfig) (transport.AuthMethod, error) {
+	user := sshConfig.User
+	if user == "" {
+		user = defaultSSHUser
+	}
+
+	var auth transport.AuthMethod
+	var helper *ssh.HostKeyCallbackHelper
+	if sshConfig.Agent {
+		agentAuth, err := ssh.NewSSHAgentAuth(user)
+		if err != nil {
+			return nil, err
+		}
+		auth, helper = agentAuth, &agentAuth.HostKeyCallbackHelper
+	} else if sshConfig.Key != "" {
+		keyAuth, err := ssh.NewPublicKeys(user, []byte(sshConfig.Key), sshConfig.KeyPassphrase)
+		if err != nil {
+			return nil, err
+		}
+		auth, helper = keyAuth, &keyAuth.HostKeyCallbackHelper
+	} else {
+		return nil, errors.New("ssh needs either a key or agent")
+	}
+
+	if sshConfig.KnownHosts == "" && sshConfig.KnownHostsFile == "" && len(sshConfig.HostKeyFingerprints) == 0 {
+		return auth, nil
+	}
+	callback, err := hostKeyCallback(sshConfig)
+	if err != nil {
+		return nil, err
+	}
+	helper.HostKeyCallback = callback
+
+	return auth, nil
+}
@@ -0,0 +1,91 @@
+package git
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	cryptossh "golang.org/x/crypto/ssh"
+	"sinanmohd.com/scid/internal/config"
+)
+
+func testHostKey(t *testing.T) cryptossh.PublicKey {
+	t.Helper()
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := cryptossh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func knownHostsLine(marker, hosts string, key cryptossh.PublicKey) string {
+	line := fmt.Sprintf("%s %s", hosts, strings.TrimSpace(string(cryptossh.MarshalAuthorizedKey(key))))
+	if marker != "" {
+		line = "@" + marker + " " + line
+	}
+	return line + "\n"
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	key, other := testHostKey(t), testHostKey(t)
+	remote := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22}
+
+	tests := []struct {
+		name         string
+		knownHosts   string
+		fingerprints []string
+		key          cryptossh.PublicKey
+		wantErr      bool
+	}{
+		{"trusted", knownHostsLine("", "host.example", key), nil, key, false},
+		{"wildcard", knownHostsLine("", "*.example", key), nil, key, false},
+		{"negated", knownHostsLine("", "*.example,!host.example", key), nil, key, true},
+		{"other key", knownHostsLine("", "host.example", other), nil, key, true},
+		{"other host", knownHostsLine("", "git.example", key), nil, key, true},
+		{"fingerprint", "", []string{cryptossh.FingerprintSHA256(key)}, key, false},
+		{
+			"revoked after trusted",
+			knownHostsLine("", "host.example", key) + knownHostsLine("revoked", "*", key),
+			nil, key, true,
+		},
+		{
+			"revoked for another host",
+			knownHostsLine("", "host.example", key) + knownHostsLine("revoked", "git.example", key),
+			nil, key, true,
+		},
+		{
+			"revoked with fingerprint",
+			knownHostsLine("revoked", "*", key),
+			[]string{cryptossh.FingerprintSHA256(key)}, key, true,
+		},
+		{
+			"other key revoked",
+			knownHostsLine("revoked", "*", other) + knownHostsLine("", "host.example", key),
+			nil, key, false,
+		},
+	}
+
+	for _, test := range tests {
+		callback, err := hostKeyCallback(&config.SSHConfig{
+			KnownHosts:          test.knownHosts,
+			HostKeyFingerprints: test.fingerprints,
+		})
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		err = callback("host.example:22", remote, test.key)
+		if test.wantErr && err == nil {
+			t.Errorf("%s: host key accepted", test.name)
+		} else if !test.wantErr && err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,421 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"lukechampine.com/blake3"
+	"sinanmohd.com/scid/internal/config"
+)
+
+const (
+	cloneMetaSuffix = ".toml"
+	lockSuffix      = ".lock"
+
+	// runs kept in the clone metadata
+	cloneRunsMax = 64
+)
+
+// clone directories are named after a blake3 sum, anything else in the
+// state directory is left alone
+var cloneNameRegex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// written next to every clone, so humans can tell what it belongs to
+type cloneMeta struct {
+	RepoUrl string `toml:"repo_url"`
+	Branch  string `toml:"branch"`
+	// last commit handed to the drivers, empty if never deployed. HEAD
+	// can be ahead of it while a deploy is held back
+	Deployed string `toml:"deployed"`
+	// targets held back by a freeze, deployed once it ends
+	Queued []string `toml:"queued,omitempty"`
+	// branch HEAD a rollback moved away from, not deployed until the
+	// branch moves on
+	Pinned string     `toml:"pinned,omitempty"`
+	Runs   []cloneRun `toml:"runs,omitempty"`
+	// helm releases deployed by scid, and declared at the last run
+	Releases []ManagedRelease `toml:"releases,omitempty"`
+	// helm clusters every declared release was deployed to, releases
+	// missing from other clusters are deployed even if unchanged
+	Clusters []string `toml:"clusters"`
+}
+
+// a helm release deployed by scid, pruned once no chart declares it
+type ManagedRelease struct {
+	// chart directory name and cluster, what Dependencies refer to
+	Chart string `toml:"chart"`
+	// chart directory name alone, what freezes name
+	ChartDir     string   `toml:"chart_dir,omitempty"`
+	Name         string   `toml:"name"`
+	Namespace    string   `toml:"namespace"`
+	Dependencies []string `toml:"dependencies,omitempty"`
+	// where it was deployed, tokens come from the cluster config
+	Cluster     string `toml:"cluster,omitempty"`
+	Kubeconfig  string `toml:"kubeconfig,omitempty"`
+	KubeContext string `toml:"kube_context,omitempty"`
+}
+
+type cloneRun struct {
+	ID       int       `toml:"id"`
+	Hash     string    `toml:"hash"`
+	Time     time.Time `toml:"time"`
+	Rollback bool      `toml:"rollback,omitempty"`
+}
+
+// jobs and helm charts update it concurrently
+var cloneMetaMu sync.Mutex
+
+func clonePath(repoUrl, branchName string) (string, error) {
+	stateDir, err := filepath.Abs(config.Config.StateDir)
+	if err != nil {
+		return "", err
+	}
+
+	sum256 := blake3.Sum256([]byte(repoUrl + branchName))
+	return filepath.Join(stateDir, fmt.Sprintf("%x", sum256)), nil
+}
+
+// the checkout scid deploys from, an error if it was not cloned yet
+func ClonePath(repoUrl, branchName string) (string, error) {
+	localPath, err := clonePath(repoUrl, branchName)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = os.Stat(localPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%s is not cloned to %s yet, run scid once", repoUrl, localPath)
+	}
+	return localPath, err
+}
+
+func cloneMetaWrite(localPath, repoUrl, branchName string) error {
+	metaPath := localPath + cloneMetaSuffix
+	_, err := os.Stat(metaPath)
+	if err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	metaFile, err := os.Create(metaPath)
+	if err != nil {
+		return err
+	}
+	defer metaFile.Close()
+
+	return toml.NewEncoder(metaFile).Encode(cloneMeta{
+		RepoUrl: repoUrl,
+		Branch:  branchName,
+	})
+}
+
+func cloneMetaUpdate(localPath string, update func(*cloneMeta)) error {
+	cloneMetaMu.Lock()
+	defer cloneMetaMu.Unlock()
+
+	metaPath := localPath + cloneMetaSuffix
+	var meta cloneMeta
+	_, err := toml.DecodeFile(metaPath, &meta)
+	if err != nil {
+		return err
+	}
+	update(&meta)
+
+	// write and rename, a crash must not lose the repo url
+	metaFile, err := os.CreateTemp(filepath.Dir(metaPath), filepath.Base(metaPath)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(metaFile.Name())
+	err = toml.NewEncoder(metaFile).Encode(meta)
+	if err != nil {
+		metaFile.Close()
+		return err
+	}
+	err = metaFile.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(metaFile.Name(), metaPath)
+}
+
+// tracked is false for metadata written before deploys were tracked
+func cloneMetaDeployed(localPath string) (deployed *plumbing.Hash, tracked bool, err error) {
+	var meta cloneMeta
+	md, err := toml.DecodeFile(localPath+cloneMetaSuffix, &meta)
+	if err != nil {
+		return nil, false, err
+	}
+	if !md.IsDefined("deployed") {
+		return nil, false, nil
+	} else if meta.Deployed == "" {
+		return nil, true, nil
+	}
+
+	hash := plumbing.NewHash(meta.Deployed)
+	return &hash, true, nil
+}
+
+// last deployed commit: nil if never deployed (or gone), HEAD if
+// deploys were not tracked yet
+func deployedHash(localPath string, repo *git.Repository, head plumbing.Hash) (*plumbing.Hash, error) {
+	hash, tracked, err := cloneMetaDeployed(localPath)
+	if err != nil {
+		return nil, err
+	}
+	if !tracked {
+		// HEAD was deployed, record it before pulling moves HEAD
+		err = cloneMetaUpdate(localPath, func(meta *cloneMeta) {
+			meta.Deployed = head.String()
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &head, nil
+	} else if hash == nil {
+		return nil, nil
+	}
+
+	_, err = repo.CommitObject(*hash)
+	if err != nil {
+		slog.Warn("last deployed commit missing, treating everything as changed", "deployed", hash, "err", err)
+		return nil, nil
+	}
+	return hash, nil
+}
+
+func cloneMetaRead(localPath string) (cloneMeta, error) {
+	cloneMetaMu.Lock()
+	defer cloneMetaMu.Unlock()
+
+	var meta cloneMeta
+	_, err := toml.DecodeFile(localPath+cloneMetaSuffix, &meta)
+	return meta, err
+}
+
+// records NewHash as deployed, the next update diffs against it.
+// returns the id of the recorded run
+func (g *Git) MarkDeployed() (int, error) {
+	var id int
+	err := cloneMetaUpdate(g.statePath, func(meta *cloneMeta) {
+		meta.Deployed = g.NewHash.String()
+		if g.Rollback {
+			meta.Pinned = g.pinHash.String()
+		}
+
+		id = 1
+		if len(meta.Runs) > 0 {
+			id = meta.Runs[len(meta.Runs)-1].ID + 1
+		}
+		meta.Runs = append(meta.Runs, cloneRun{
+			ID:       id,
+			Hash:     g.NewHash.String(),
+			Time:     time.Now(),
+			Rollback: g.Rollback,
+		})
+		if len(meta.Runs) > cloneRunsMax {
+			meta.Runs = meta.Runs[len(meta.Runs)-cloneRunsMax:]
+		}
+	})
+
+	return id, err
+}
+
+func (g *Git) ManagedReleases() ([]ManagedRelease, error) {
+	meta, err := cloneMetaRead(g.statePath)
+	if err != nil {
+		return nil, err
+	}
+	return meta.Releases, nil
+}
+
+// tracked is false for metadata written before clusters were tracked
+func (g *Git) ManagedClusters() (clusters []string, tracked bool, err error) {
+	cloneMetaMu.Lock()
+	defer cloneMetaMu.Unlock()
+
+	var meta cloneMeta
+	md, err := toml.DecodeFile(g.statePath+cloneMetaSuffix, &meta)
+	if err != nil {
+		return nil, false, err
+	}
+	return meta.Clusters, md.IsDefined("clusters"), nil
+}
+
+func (g *Git) ManagedReleasesSet(releases []ManagedRelease, clusters []string) error {
+	return cloneMetaUpdate(g.statePath, func(meta *cloneMeta) {
+		meta.Releases = releases
+		meta.Clusters = clusters
+	})
+}
+
+// true if NewHash was rolled back from, the pin is dropped once the
+// branch moves on
+func (g *Git) Pinned() (bool, error) {
+	meta, err := cloneMetaRead(g.statePath)
+	if err != nil {
+		return false, err
+	}
+	if meta.Pinned == "" {
+		return false, nil
+	} else if meta.Pinned == g.NewHash.String() {
+		return true, nil
+	}
+
+	slog.Info("branch moved on, dropping rollback pin", "pinned", meta.Pinned, "newHash", g.NewHash)
+	return false, cloneMetaUpdate(g.statePath, func(meta *cloneMeta) {
+		meta.Pinned = ""
+	})
+}
+
+// serializes updates and rollbacks of a clone, across processes too
+func Lock(repoUrl, branchName string) (func(), error) {
+	localPath, err := clonePath(repoUrl, branchName)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Dir(localPath), 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	lockFile, err := os.OpenFile(localPath+lockSuffix, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		lockFile.Close()
+		return nil, err
+	}
+
+	return func() {
+		// closing releases the lock
+		lockFile.Close()
+	}, nil
+}
+
+func (g *Git) Queue(target string) error {
+	return cloneMetaUpdate(g.statePath, func(meta *cloneMeta) {
+		if !slices.Contains(meta.Queued, target) {
+			meta.Queued = append(meta.Queued, target)
+		}
+	})
+}
+
+func (g *Git) Dequeue(target string) error {
+	return cloneMetaUpdate(g.statePath, func(meta *cloneMeta) {
+		meta.Queued = slices.DeleteFunc(meta.Queued, func(queued string) bool {
+			return queued == target
+		})
+	})
+}
+
+// drops queued targets no longer declared, they would never be dequeued
+func (g *Git) QueuePrune(declared []string) error {
+	return cloneMetaUpdate(g.statePath, func(meta *cloneMeta) {
+		meta.Queued = slices.DeleteFunc(meta.Queued, func(queued string) bool {
+			if slices.Contains(declared, queued) {
+				return false
+			}
+			slog.Info("target not declared anymore, dropping it from the freeze queue", "target", queued)
+			return true
+		})
+	})
+}
+
+func (g *Git) Queued() ([]string, error) {
+	meta, err := cloneMetaRead(g.statePath)
+	if err != nil {
+		return nil, err
+	}
+	return meta.Queued, nil
+}
+
+// removes clones, broken clones and their metadata of repoUrl not
+// belonging to branchName. other repos may be another scid's state
+func GC(repoUrl, branchName string) error {
+	localPath, err := clonePath(repoUrl, branchName)
+	if err != nil {
+		return err
+	}
+	stateDir := filepath.Dir(localPath)
+	entries, err := os.ReadDir(stateDir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	orphans := make(map[string]bool)
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), cloneMetaSuffix)
+		name = strings.TrimSuffix(name, brokenSuffix)
+		name = strings.TrimSuffix(name, lockSuffix)
+		if cloneNameRegex.MatchString(name) && name != filepath.Base(localPath) {
+			orphans[name] = true
+		}
+	}
+
+	for name := range orphans {
+		orphanPath := filepath.Join(stateDir, name)
+		var meta cloneMeta
+		_, err := toml.DecodeFile(orphanPath+cloneMetaSuffix, &meta)
+		if err != nil {
+			slog.Warn("not removing clone without readable metadata", "path", orphanPath, "err", err)
+			continue
+		} else if meta.RepoUrl != repoUrl {
+			continue
+		}
+
+		lockFile, err := os.OpenFile(orphanPath+lockSuffix, os.O_CREATE|os.O_RDWR, 0o644)
+		if err != nil {
+			return err
+		}
+		// another scid sharing the state dir is updating it
+		err = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			lockFile.Close()
+			slog.Info("orphaned clone is in use, not removing it", "path", orphanPath)
+			continue
+		} else if err != nil {
+			lockFile.Close()
+			return err
+		}
+
+		slog.Info("removing orphaned clone", "path", orphanPath, "repoUrl", meta.RepoUrl, "branch", meta.Branch)
+		err = removeOrphan(orphanPath)
+		lockFile.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// the lock file goes last, the caller holds it
+func removeOrphan(orphanPath string) error {
+	for _, path := range []string{orphanPath, orphanPath + brokenSuffix, orphanPath + cloneMetaSuffix, orphanPath + lockSuffix} {
+		err := os.RemoveAll(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
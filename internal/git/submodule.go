@@ -0,0 +1,164 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/filemode"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"github.com/go-git/go-git/v6/plumbing/transport"
+	"sinanmohd.com/scid/internal/config"
+)
+
+func submoduleDepth() git.SubmoduleRecursivity {
+	switch config.Config.Clone.Submodules {
+	case config.SubmodulesEnabled:
+		return 1
+	case config.SubmodulesRecursive:
+		return git.DefaultSubmoduleRecursionDepth
+	default:
+		return git.NoRecurseSubmodules
+	}
+}
+
+func updateSubmodules(workTree *git.Worktree, auth transport.AuthMethod) error {
+	depth := submoduleDepth()
+	if depth == git.NoRecurseSubmodules {
+		return nil
+	}
+
+	submodules, err := workTree.Submodules()
+	if err != nil {
+		return err
+	}
+
+	return submodules.Update(&git.SubmoduleUpdateOptions{
+		Init: true,
+		// the top level is depth 1
+		RecurseSubmodules: depth - 1,
+		Auth:              auth,
+		Depth:             config.Config.Clone.Depth,
+	})
+}
+
+var errSubmoduleNotFound = errors.New("submodule not found")
+
+func submoduleRepo(repo *git.Repository, subPath string) (*git.Repository, error) {
+	workTree, err := repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	submodules, err := workTree.Submodules()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, submodule := range submodules {
+		if submodule.Config().Path == subPath {
+			return submodule.Repository()
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", errSubmoduleNotFound, subPath)
+}
+
+// objects of a submodule no longer in .gitmodules, if it was ever cloned
+func submoduleStorage(repo *git.Repository, subPath string) (*git.Repository, error) {
+	storer, err := repo.Storer.Module(subPath)
+	if err != nil {
+		return nil, err
+	}
+	_, err = storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return nil, err
+	}
+
+	return git.Open(storer, nil)
+}
+
+// nil for the zero hash, gitlinks of added or removed submodules
+func treeAt(repo *git.Repository, hash plumbing.Hash) (*object.Tree, error) {
+	if hash.IsZero() {
+		return nil, nil
+	}
+
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	return commit.Tree()
+}
+
+func gitlinkHash(entry object.ChangeEntry) plumbing.Hash {
+	if entry.TreeEntry.Mode != filemode.Submodule {
+		return plumbing.ZeroHash
+	}
+
+	return entry.TreeEntry.Hash
+}
+
+func isSubmoduleChange(change *object.Change) bool {
+	return change.From.TreeEntry.Mode == filemode.Submodule || change.To.TreeEntry.Mode == filemode.Submodule
+}
+
+// expands a gitlink bump into the paths that changed inside the submodule,
+// relative to the top level repo
+func submoduleChangedPaths(repo *git.Repository, prefix string, change *object.Change, depth git.SubmoduleRecursivity) ([]string, error) {
+	subPath := change.To.Name
+	if subPath == "" {
+		subPath = change.From.Name
+	}
+	subRepo, err := submoduleRepo(repo, subPath)
+	if errors.Is(err, errSubmoduleNotFound) || (err != nil && gitlinkHash(change.To).IsZero()) {
+		// removed or moved away, its objects stay behind in .git/modules
+		subRepo, err = submoduleStorage(repo, subPath)
+		if err != nil {
+			// the caller already reports subPath
+			slog.Warn("submodule gone, not diffing inside it", "submodule", path.Join(prefix, subPath), "err", err)
+			return nil, nil
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	to, err := treeAt(subRepo, gitlinkHash(change.To))
+	if err != nil {
+		return nil, err
+	}
+	from, err := treeAt(subRepo, gitlinkHash(change.From))
+	if err != nil {
+		// eg: after a re-clone, everything in the submodule is new then
+		slog.Warn("old submodule commit missing, treating all of it as changed", "submodule", path.Join(prefix, subPath), "err", err)
+		from = nil
+	}
+
+	changes, err := object.DiffTreeWithOptions(context.Background(), from, to, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var changedPaths []string
+	for _, subChange := range changes {
+		if subChange.From.Name != "" {
+			changedPaths = append(changedPaths, path.Join(prefix, subPath, subChange.From.Name))
+		}
+		if subChange.To.Name != "" && subChange.To.Name != subChange.From.Name {
+			changedPaths = append(changedPaths, path.Join(prefix, subPath, subChange.To.Name))
+		}
+
+		if depth > 1 && isSubmoduleChange(subChange) {
+			nestedPaths, err := submoduleChangedPaths(subRepo, path.Join(prefix, subPath), subChange, depth-1)
+			if err != nil {
+				return nil, err
+			}
+			changedPaths = append(changedPaths, nestedPaths...)
+		}
+	}
+
+	return changedPaths, nil
+}
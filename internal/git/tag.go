@@ -0,0 +1,240 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	gitconfig "github.com/go-git/go-git/v6/config"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"github.com/go-git/go-git/v6/plumbing/transport"
+	"sinanmohd.com/scid/internal/config"
+)
+
+type branchTag struct {
+	name string
+	hash plumbing.Hash
+	// tagger date for annotated tags, commit date otherwise
+	when time.Time
+}
+
+type TagMovedError struct {
+	Tag              string
+	OldHash, NewHash plumbing.Hash
+}
+
+func (e *TagMovedError) Error() string {
+	return fmt.Sprintf("tag %s moved from %s to %s", e.Tag, e.OldHash, e.NewHash)
+}
+
+func remoteBranchRefName(branchName string) plumbing.ReferenceName {
+	return plumbing.NewRemoteReferenceName(git.DefaultRemoteName, branchName)
+}
+
+// fetches the branch into its remote tracking ref and all tags, moved tags
+// are overwritten. the local branch and the worktree are left untouched
+func fetchBranchTags(branchName string, repo *git.Repository, auth transport.AuthMethod) error {
+	err := repo.Fetch(&git.FetchOptions{
+		RefSpecs: []gitconfig.RefSpec{branchRefSpec(branchName)},
+		Tags:     git.AllTags,
+		Force:    true,
+		Auth:     auth,
+		Depth:    config.Config.Clone.Depth,
+		Filter:   cloneFilter(),
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return err
+	}
+
+	return nil
+}
+
+// tags pointing to commits reachable from the remote branch
+func branchTags(branchName string, repo *git.Repository) ([]branchTag, error) {
+	branchRef, err := repo.Reference(remoteBranchRefName(branchName), true)
+	if err != nil {
+		return nil, err
+	}
+	commits, err := repo.Log(&git.LogOptions{From: branchRef.Hash()})
+	if err != nil {
+		return nil, err
+	}
+	reachable := make(map[plumbing.Hash]*object.Commit)
+	err = commits.ForEach(func(commit *object.Commit) error {
+		reachable[commit.Hash] = commit
+		return nil
+	})
+	// shallow clones end at the clone depth, older tags are not seen
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		slog.Warn("commit history incomplete, tags beyond the clone depth are ignored", "err", err)
+	} else if err != nil {
+		return nil, err
+	}
+
+	tagRefs, err := repo.Tags()
+	if err != nil {
+		return nil, err
+	}
+	var tags []branchTag
+	err = tagRefs.ForEach(func(tagRef *plumbing.Reference) error {
+		tag := branchTag{
+			name: tagRef.Name().Short(),
+			hash: tagRef.Hash(),
+		}
+
+		tagObject, err := repo.TagObject(tagRef.Hash())
+		if err == nil {
+			commit, err := tagObject.Commit()
+			if errors.Is(err, object.ErrUnsupportedObject) {
+				// tags of trees or blobs
+				return nil
+			} else if err != nil {
+				return err
+			}
+			tag.hash = commit.Hash
+			tag.when = tagObject.Tagger.When
+		} else if !errors.Is(err, plumbing.ErrObjectNotFound) {
+			return err
+		}
+
+		commit, ok := reachable[tag.hash]
+		if !ok {
+			return nil
+		}
+		if tag.when.IsZero() {
+			tag.when = commit.Committer.When
+		}
+
+		tags = append(tags, tag)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+func newestTag(tags []branchTag) (string, error) {
+	if len(tags) <= 0 {
+		return "", errors.New("no matching tag")
+	}
+
+	newest := slices.MaxFunc(tags, func(a, b branchTag) int {
+		return a.when.Compare(b.when)
+	})
+	return newest.name, nil
+}
+
+func tagName(tag *config.Tag, branchName string, repo *git.Repository) (string, error) {
+	if tag.Model == config.TagModelStatic {
+		return tag.Value, nil
+	}
+
+	tags, err := branchTags(branchName, repo)
+	if err != nil {
+		return "", err
+	}
+
+	switch tag.Model {
+	case config.TagModelSemver:
+		return latestSemverTag(tag, tags)
+	case config.TagModelGlob:
+		var matched []branchTag
+		for _, branchTag := range tags {
+			ok, err := path.Match(tag.Value, branchTag.name)
+			if err != nil {
+				return "", err
+			} else if ok {
+				matched = append(matched, branchTag)
+			}
+		}
+		return newestTag(matched)
+	case config.TagModelLatest:
+		return newestTag(tags)
+	default:
+		return "", fmt.Errorf("unsupported tag model: %s", tag.Model)
+	}
+}
+
+// returns nil for lightweight tags and static revisions that are not tags
+func tagObjectFor(name string, repo *git.Repository) (*object.Tag, error) {
+	tagRef, err := repo.Tag(name)
+	if errors.Is(err, git.ErrTagNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	tagObject, err := repo.TagObject(tagRef.Hash())
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		return nil, nil
+	}
+	return tagObject, err
+}
+
+func peeledTagHash(name string, repo *git.Repository) (*plumbing.Hash, error) {
+	return repo.ResolveRevision(plumbing.Revision(name))
+}
+
+// fetches and detaches HEAD onto the selected tag, returns the tag name
+func checkoutTag(tag *config.Tag, branchName string, repo *git.Repository, auth transport.AuthMethod) (string, error) {
+	var oldTagRef *plumbing.Reference
+	var oldHash *plumbing.Hash
+	if tag.Model == config.TagModelStatic {
+		var err error
+		oldTagRef, err = repo.Tag(tag.Value)
+		if err == nil {
+			oldHash, err = peeledTagHash(tag.Value, repo)
+		}
+		if err != nil && !errors.Is(err, git.ErrTagNotFound) {
+			return "", err
+		}
+	}
+
+	err := fetchBranchTags(branchName, repo, auth)
+	if err != nil {
+		return "", err
+	}
+
+	name, err := tagName(tag, branchName, repo)
+	if err != nil {
+		return "", err
+	}
+	hash, err := peeledTagHash(name, repo)
+	if err != nil {
+		return "", err
+	}
+
+	if oldHash != nil && *oldHash != *hash {
+		moved := &TagMovedError{Tag: name, OldHash: *oldHash, NewHash: *hash}
+		if tag.OnMove != config.TagMoveRefuse {
+			slog.Warn("static tag was force moved, deploying", "tag", name, "oldHash", moved.OldHash, "newHash", moved.NewHash)
+		} else {
+			// restore it so the move is detected again on the next pull
+			err = repo.Storer.SetReference(oldTagRef)
+			if err != nil {
+				return "", err
+			}
+			return "", moved
+		}
+	}
+
+	workTree, err := repo.Worktree()
+	if err != nil {
+		return "", err
+	}
+	err = checkoutSparse(repo, workTree, &git.CheckoutOptions{
+		Hash: *hash,
+	})
+	if err != nil {
+		return "", fmt.Errorf("checking out tag %s: %w", name, err)
+	}
+
+	return name, nil
+}
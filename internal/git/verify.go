@@ -0,0 +1,215 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"golang.org/x/crypto/ssh"
+	"sinanmohd.com/scid/internal/config"
+)
+
+const (
+	sshSigMagic     = "SSHSIG"
+	sshSigNamespace = "git"
+	sshSigArmorHead = "-----BEGIN SSH SIGNATURE-----"
+)
+
+var ErrNotSigned = errors.New("not signed")
+
+type UnverifiedError struct {
+	OldHash *plumbing.Hash
+	Hash    plumbing.Hash
+	Err     error
+}
+
+func (e *UnverifiedError) Error() string {
+	return fmt.Sprintf("verifying signature of %s: %s", e.Hash, e.Err)
+}
+
+func (e *UnverifiedError) Unwrap() error {
+	return e.Err
+}
+
+// https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig
+type sshSig struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     []byte
+}
+
+type sshSigSignedData struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          []byte
+}
+
+func sshAllowedKeys(sshKeys string) ([]ssh.PublicKey, error) {
+	var keys []ssh.PublicKey
+	rest := []byte(sshKeys)
+	for len(bytes.TrimSpace(rest)) > 0 {
+		key, _, _, next, err := ssh.ParseAuthorizedKey(rest)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		rest = next
+	}
+
+	return keys, nil
+}
+
+func sshVerify(sshKeys, armoredSig string, payload []byte) error {
+	block, _ := pem.Decode([]byte(armoredSig))
+	if block == nil {
+		return errors.New("malformed ssh signature")
+	}
+	blob, found := bytes.CutPrefix(block.Bytes, []byte(sshSigMagic))
+	if !found {
+		return errors.New("malformed ssh signature")
+	}
+
+	var sig sshSig
+	err := ssh.Unmarshal(blob, &sig)
+	if err != nil {
+		return err
+	}
+	if sig.Namespace != sshSigNamespace {
+		return fmt.Errorf("unexpected ssh signature namespace: %s", sig.Namespace)
+	}
+
+	signer, err := ssh.ParsePublicKey(sig.PublicKey)
+	if err != nil {
+		return err
+	}
+	allowedKeys, err := sshAllowedKeys(sshKeys)
+	if err != nil {
+		return err
+	}
+	allowed := false
+	for _, key := range allowedKeys {
+		if bytes.Equal(key.Marshal(), signer.Marshal()) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("signed by unknown key %s", ssh.FingerprintSHA256(signer))
+	}
+
+	var h hash.Hash
+	switch sig.HashAlgorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return fmt.Errorf("unsupported ssh signature hash algorithm: %s", sig.HashAlgorithm)
+	}
+	h.Write(payload)
+
+	signedData := append([]byte(sshSigMagic), ssh.Marshal(sshSigSignedData{
+		Namespace:     sig.Namespace,
+		HashAlgorithm: sig.HashAlgorithm,
+		Hash:          h.Sum(nil),
+	})...)
+	signature := new(ssh.Signature)
+	err = ssh.Unmarshal(sig.Signature, signature)
+	if err != nil {
+		return err
+	}
+
+	return signer.Verify(signedData, signature)
+}
+
+func objectPayload(o interface {
+	EncodeWithoutSignature(plumbing.EncodedObject) error
+}) ([]byte, error) {
+	encoded := &plumbing.MemoryObject{}
+	err := o.EncodeWithoutSignature(encoded)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := encoded.Reader()
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(reader)
+}
+
+func verifySignature(verify *config.VerifyConfig, signature string, payload func() ([]byte, error), gpgVerify func(string) error) error {
+	if signature == "" {
+		return ErrNotSigned
+	}
+
+	if strings.HasPrefix(signature, sshSigArmorHead) {
+		if verify.SSHKeys == "" {
+			return errors.New("ssh signature but no ssh keys configured")
+		}
+		data, err := payload()
+		if err != nil {
+			return err
+		}
+		return sshVerify(verify.SSHKeys, signature, data)
+	}
+
+	if verify.GPGKeys == "" {
+		return errors.New("gpg signature but no gpg keys configured")
+	}
+	return gpgVerify(verify.GPGKeys)
+}
+
+func verifyCommit(verify *config.VerifyConfig, commit *object.Commit) error {
+	return verifySignature(verify, commit.PGPSignature,
+		func() ([]byte, error) { return objectPayload(commit) },
+		func(keyRing string) error {
+			_, err := commit.Verify(keyRing)
+			return err
+		},
+	)
+}
+
+func verifyTag(verify *config.VerifyConfig, tag *object.Tag) error {
+	return verifySignature(verify, tag.PGPSignature,
+		func() ([]byte, error) { return objectPayload(tag) },
+		func(keyRing string) error {
+			_, err := tag.Verify(keyRing)
+			return err
+		},
+	)
+}
+
+// verifyHead checks the signature of the checked out commit, if a tag was
+// selected a signed annotated tag is enough, lightweight tags fall back
+// to the commit signature
+func verifyHead(verify *config.VerifyConfig, tagName string, repo *git.Repository, hash plumbing.Hash) error {
+	if tagName != "" {
+		tagObject, err := tagObjectFor(tagName, repo)
+		if err != nil {
+			return err
+		}
+		if tagObject != nil && tagObject.PGPSignature != "" {
+			return verifyTag(verify, tagObject)
+		}
+	}
+
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return err
+	}
+	return verifyCommit(verify, commit)
+}
@@ -0,0 +1,234 @@
+package git
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/pem"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v6/memfs"
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"github.com/go-git/go-git/v6/storage/memory"
+	cryptossh "golang.org/x/crypto/ssh"
+	"sinanmohd.com/scid/internal/config"
+)
+
+// ssh-keygen -Y sign -n git of testSSHPayload
+const (
+	testSSHKey     = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIEsPUckp64RfDzDHWwZzG8MAZfKu1zhYOgcpTtC15NTl\n"
+	testSSHPayload = "scid payload\n"
+	testSSHSig     = `-----BEGIN SSH SIGNATURE-----
+U1NIU0lHAAAAAQAAADMAAAALc3NoLWVkMjU1MTkAAAAgSw9RySnrhF8PMMdbBnMbwwBl8q
+7XOFg6BylO0LXk1OUAAAADZ2l0AAAAAAAAAAZzaGE1MTIAAABTAAAAC3NzaC1lZDI1NTE5
+AAAAQCvl22ea7IpI5DcQ+KzC1kw+P6uerMp2BoHdUv62lgbNvfDk5LKQWl6CXuZ/1wJoH5
+8iQBqnbqLnXIxgfz7JVw4=
+-----END SSH SIGNATURE-----
+`
+)
+
+type testSigner struct {
+	key           ed25519.PrivateKey
+	namespace     string
+	hashAlgorithm string
+}
+
+func newTestSigner(t *testing.T) *testSigner {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testSigner{key: key, namespace: sshSigNamespace, hashAlgorithm: "sha512"}
+}
+
+func (s *testSigner) authorizedKey(t *testing.T) string {
+	t.Helper()
+	public, err := cryptossh.NewPublicKey(s.key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(cryptossh.MarshalAuthorizedKey(public))
+}
+
+// armored signature in the PROTOCOL.sshsig format, as git would store it
+func (s *testSigner) Sign(message io.Reader) ([]byte, error) {
+	payload, err := io.ReadAll(message)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := cryptossh.NewSignerFromKey(s.key)
+	if err != nil {
+		return nil, err
+	}
+
+	var digest []byte
+	if s.hashAlgorithm == "sha256" {
+		sum := sha256.Sum256(payload)
+		digest = sum[:]
+	} else {
+		sum := sha512.Sum512(payload)
+		digest = sum[:]
+	}
+	signedData := append([]byte(sshSigMagic), cryptossh.Marshal(sshSigSignedData{
+		Namespace:     s.namespace,
+		HashAlgorithm: s.hashAlgorithm,
+		Hash:          digest,
+	})...)
+	signature, err := signer.Sign(rand.Reader, signedData)
+	if err != nil {
+		return nil, err
+	}
+
+	blob := append([]byte(sshSigMagic), cryptossh.Marshal(sshSig{
+		Version:       1,
+		PublicKey:     signer.PublicKey().Marshal(),
+		Namespace:     s.namespace,
+		HashAlgorithm: s.hashAlgorithm,
+		Signature:     cryptossh.Marshal(signature),
+	})...)
+	return pem.EncodeToMemory(&pem.Block{Type: "SSH SIGNATURE", Bytes: blob}), nil
+}
+
+func (s *testSigner) sign(t *testing.T, payload string) string {
+	t.Helper()
+	sig, err := s.Sign(strings.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(sig)
+}
+
+func TestSSHVerify(t *testing.T) {
+	signer, other := newTestSigner(t), newTestSigner(t)
+	sha256Signer := &testSigner{key: signer.key, namespace: sshSigNamespace, hashAlgorithm: "sha256"}
+	fileSigner := &testSigner{key: signer.key, namespace: "file", hashAlgorithm: "sha512"}
+	md5Signer := &testSigner{key: signer.key, namespace: sshSigNamespace, hashAlgorithm: "md5"}
+	payload := "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n\ncommit\n"
+
+	tests := []struct {
+		name    string
+		keys    string
+		sig     string
+		payload string
+		wantErr bool
+	}{
+		{"ssh-keygen", testSSHKey, testSSHSig, testSSHPayload, false},
+		{"ssh-keygen tampered", testSSHKey, testSSHSig, "scid payload!\n", true},
+		{"valid", signer.authorizedKey(t), signer.sign(t, payload), payload, false},
+		{"sha256", signer.authorizedKey(t), sha256Signer.sign(t, payload), payload, false},
+		{"allowed signers", "scid@example.com " + signer.authorizedKey(t), signer.sign(t, payload), payload, false},
+		{"one of many keys", other.authorizedKey(t) + signer.authorizedKey(t), signer.sign(t, payload), payload, false},
+		{"tampered", signer.authorizedKey(t), signer.sign(t, payload), payload + "x", true},
+		{"wrong namespace", signer.authorizedKey(t), fileSigner.sign(t, payload), payload, true},
+		{"key not allowed", other.authorizedKey(t), signer.sign(t, payload), payload, true},
+		{"unsupported hash", signer.authorizedKey(t), md5Signer.sign(t, payload), payload, true},
+		{"not armored", signer.authorizedKey(t), "SSHSIG", payload, true},
+	}
+
+	for _, test := range tests {
+		err := sshVerify(test.keys, test.sig, []byte(test.payload))
+		if test.wantErr && err == nil {
+			t.Errorf("%s: signature accepted", test.name)
+		} else if !test.wantErr && err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		}
+	}
+}
+
+func testTag(t *testing.T, repo *git.Repository, name string, target plumbing.Hash, signer *testSigner) {
+	t.Helper()
+	tag := &object.Tag{
+		Name:       name,
+		Tagger:     object.Signature{Name: "scid", Email: "scid@example.com", When: time.Now()},
+		Message:    name + "\n",
+		TargetType: plumbing.CommitObject,
+		Target:     target,
+	}
+	if signer != nil {
+		payload, err := objectPayload(tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tag.PGPSignature = signer.sign(t, string(payload))
+	}
+
+	encoded := repo.Storer.NewEncodedObject()
+	err := tag.Encode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := repo.Storer.SetEncodedObject(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.Storer.SetReference(plumbing.NewHashReference(plumbing.NewTagReferenceName(name), hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyHead(t *testing.T) {
+	repo, err := git.Init(memory.NewStorage(), git.WithWorkTree(memfs.New()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	workTree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, other := newTestSigner(t), newTestSigner(t)
+	verify := &config.VerifyConfig{SSHKeys: signer.authorizedKey(t)}
+
+	unsigned := testCommit(t, repo, "unsigned", time.Now())
+	signature := &object.Signature{Name: "scid", Email: "scid@example.com", When: time.Now()}
+	signed, err := workTree.Commit("signed", &git.CommitOptions{
+		AllowEmptyCommits: true,
+		Author:            signature,
+		Committer:         signature,
+		Parents:           []plumbing.Hash{unsigned},
+		Signer:            signer,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testTag(t, repo, "signed", unsigned, signer)
+	testTag(t, repo, "unsigned", unsigned, nil)
+	testTag(t, repo, "other", unsigned, other)
+	testTag(t, repo, "unsigned-on-signed", signed, nil)
+	err = repo.Storer.SetReference(plumbing.NewHashReference(plumbing.NewTagReferenceName("lightweight"), signed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		tag     string
+		hash    plumbing.Hash
+		wantErr bool
+	}{
+		{"signed commit", "", signed, false},
+		{"unsigned commit", "", unsigned, true},
+		{"signed tag, unsigned commit", "signed", unsigned, false},
+		{"unsigned tag, unsigned commit", "unsigned", unsigned, true},
+		{"unsigned tag, signed commit", "unsigned-on-signed", signed, false},
+		{"lightweight tag, signed commit", "lightweight", signed, false},
+		{"tag signed by unknown key", "other", unsigned, true},
+	}
+
+	for _, test := range tests {
+		err := verifyHead(verify, test.tag, repo, test.hash)
+		if test.wantErr && err == nil {
+			t.Errorf("%s: signature accepted", test.name)
+		} else if !test.wantErr && err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		}
+	}
+}
@@ -1,16 +1,41 @@
 package health
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"log"
 	"net/http"
+
+	"sinanmohd.com/scid/internal/config"
+	"sinanmohd.com/scid/internal/freeze"
+	"sinanmohd.com/scid/internal/metrics"
 )
 
+// requires the api token as bearer token, the api is off without one
+func Authenticated(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := config.Config.APIToken
+		if token == "" {
+			http.Error(w, "api is disabled", http.StatusNotFound)
+			return
+		}
+		auth := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(auth, []byte("Bearer "+token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
 func Init() {
 	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprint(w, "OK")
 	})
+	http.HandleFunc("/metrics", metrics.Handler)
+	http.HandleFunc("/freeze/override", Authenticated(freeze.OverrideHandler))
 	go func() {
 		err := http.ListenAndServe(":8008", nil)
 		if err != nil {
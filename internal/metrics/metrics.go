@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+type Counter struct {
+	name, help string
+	value      atomic.Uint64
+}
+
+var (
+	countersMu sync.Mutex
+	counters   []*Counter
+)
+
+func NewCounter(name, help string) *Counter {
+	countersMu.Lock()
+	defer countersMu.Unlock()
+
+	counter := &Counter{name: name, help: help}
+	counters = append(counters, counter)
+	return counter
+}
+
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// prometheus text exposition format
+func Handler(w http.ResponseWriter, r *http.Request) {
+	countersMu.Lock()
+	defer countersMu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, counter := range counters {
+		fmt.Fprintf(w, "# HELP %s %s\n", counter.name, counter.help)
+		fmt.Fprintf(w, "# TYPE %s counter\n", counter.name)
+		fmt.Fprintf(w, "%s %d\n", counter.name, counter.value.Load())
+	}
+}